@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"dagger/cargo/internal/dagger"
+)
+
+const (
+	ARTIFACTS_LOG = "/tmp/artifacts.json"
+	// Copies of the artifacts, each at its own path under this directory
+	ARTIFACTS_STAGE = "/tmp/artifacts"
+)
+
+// Run the build command given as arguments, then copy the files of the
+// compiler-artifact messages of the project's own packages to ARTIFACTS_STAGE.
+// Both happen in one exec: the target cache is shared by every caller, so a
+// later exec could copy what another build wrote there, or fail on a pruned
+// volume when the build itself is cached
+const STAGE_ARTIFACTS = `"$@" > ` + ARTIFACTS_LOG + ` && mkdir -p ` + ARTIFACTS_STAGE + PROJ_TARGET + ` &&
+grep '^{"reason":"compiler-artifact"' ` + ARTIFACTS_LOG + ` |
+grep '"package_id":"[^"]*path+file://' |
+grep -o '"filenames":\[[^]]*\]' |
+sed 's/^"filenames":\[//; s/\]$//' | tr ',' '\n' | tr -d '"' | sort -u |
+while read -r f; do mkdir -p "` + ARTIFACTS_STAGE + `$(dirname "$f")" && cp -p "$f" "` + ARTIFACTS_STAGE + `$f" || exit 1; done`
+
+// Message printed by cargo with --message-format=json for each built target
+type artifactMessage struct {
+	Reason    string `json:"reason"`
+	PackageID string `json:"package_id"`
+	Target    struct {
+		Kind []string `json:"kind"`
+	} `json:"target"`
+	Filenames  []string `json:"filenames"`
+	Executable *string  `json:"executable"`
+}
+
+// Run a cargo build command and return the files it reports in
+// compiler-artifact messages, selected by keep. Unlike globbing target/, this
+// never picks up what earlier builds left in the target cache
+func buildArtifacts(
+	ctx context.Context,
+	ctr *dagger.Container,
+	command []string,
+	keep func(artifactMessage) []string,
+) (*dagger.Directory, error) {
+	ctr = stageArtifacts(ctr, command)
+	out, err := ctr.File(ARTIFACTS_LOG).Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files := parseArtifacts(out, keep)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s built no artifacts", strings.Join(command, " "))
+	}
+	dir := dag.Directory()
+	for _, f := range files {
+		dir = dir.WithFile(path.Base(f), ctr.File(ARTIFACTS_STAGE+f))
+	}
+	return dir, nil
+}
+
+// Run a cargo build command with JSON messages and stage its artifacts, see STAGE_ARTIFACTS
+func stageArtifacts(ctr *dagger.Container, command []string) *dagger.Container {
+	command = slices.Insert(slices.Clone(command), 2, "--message-format=json-render-diagnostics")
+	return ctr.WithExec(append([]string{"sh", "-c", STAGE_ARTIFACTS, "sh"}, command...))
+}
+
+// Files of the compiler-artifact messages of the project's own packages selected by keep
+func parseArtifacts(output string, keep func(artifactMessage) []string) []string {
+	var files []string
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg artifactMessage
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &msg) != nil {
+			continue
+		}
+		if msg.Reason == "compiler-artifact" && strings.Contains(msg.PackageID, "path+file://") {
+			files = append(files, keep(msg)...)
+		}
+	}
+	slices.Sort(files)
+	return slices.Compact(files)
+}
+
+// Binaries of bin targets
+func executables(msg artifactMessage) []string {
+	if msg.Executable == nil || !slices.Contains(msg.Target.Kind, "bin") {
+		return nil
+	}
+	return []string{*msg.Executable}
+}
+
+// WebAssembly modules of cdylib and bin targets
+func wasmModules(msg artifactMessage) []string {
+	var modules []string
+	for _, f := range msg.Filenames {
+		if strings.HasSuffix(f, ".wasm") {
+			modules = append(modules, f)
+		}
+	}
+	return modules
+}
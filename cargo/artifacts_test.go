@@ -0,0 +1,22 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseArtifacts(t *testing.T) {
+	output := `{"reason":"compiler-artifact","package_id":"registry+https://github.com/rust-lang/crates.io-index#tool@1.0.0","target":{"kind":["bin"]},"filenames":["/src/target/release/tool"],"executable":"/src/target/release/tool"}
+{"reason":"compiler-artifact","package_id":"path+file:///src#app@0.1.0","target":{"kind":["lib"]},"filenames":["/src/target/release/libapp.rlib"],"executable":null}
+{"reason":"compiler-artifact","package_id":"path+file:///src#app@0.1.0","target":{"kind":["bin"]},"filenames":["/src/target/release/app"],"executable":"/src/target/release/app"}
+{"reason":"compiler-artifact","package_id":"app 0.1.0 (path+file:///src)","target":{"kind":["cdylib"]},"filenames":["/src/target/wasm32-unknown-unknown/release/app.wasm","/src/target/wasm32-unknown-unknown/release/app.d"],"executable":null}
+{"reason":"compiler-artifact","package_id":"path+file:///src#app@0.1.0","target":{"kind":["bin"]},"filenames":["/src/target/release/app"],"executable":"/src/target/release/app"}
+{"reason":"build-finished","success":true}
+`
+	if got := parseArtifacts(output, executables); !slices.Equal(got, []string{"/src/target/release/app"}) {
+		t.Fatalf("executables %q", got)
+	}
+	if got := parseArtifacts(output, wasmModules); !slices.Equal(got, []string{"/src/target/wasm32-unknown-unknown/release/app.wasm"}) {
+		t.Fatalf("wasm modules %q", got)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"dagger/cargo/internal/dagger"
+)
+
+const (
+	ADVISORY_DB         = "/advisory-db"
+	DENY_CONFIG         = "/deny.toml"
+	CARGO_AUDIT_VERSION = "0.21.2"
+	CARGO_DENY_VERSION  = "0.18.3"
+)
+
+// Ordered severities, findings are failed on at or above a threshold
+var severities = []string{"none", "low", "medium", "high", "critical"}
+
+// A supply-chain issue found by cargo audit or cargo deny
+type Finding struct {
+	Crate   string
+	Version string
+	// Advisory ID, e.g. RUSTSEC-2023-0001
+	Advisory string
+	// One of none, low, medium, high, critical or unknown
+	Severity string
+	// License expression, for license findings
+	License string
+	// vulnerability, unmaintained, yanked, license, ...
+	Kind  string
+	Title string
+}
+
+// Output of `cargo audit --json`
+type auditOutput struct {
+	Vulnerabilities struct {
+		List []auditEntry `json:"list"`
+	} `json:"vulnerabilities"`
+	Warnings map[string][]auditEntry `json:"warnings"`
+}
+
+type auditEntry struct {
+	Kind     string         `json:"kind"`
+	Advisory *auditAdvisory `json:"advisory"`
+	Package  struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"package"`
+}
+
+type auditAdvisory struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Cvss  string `json:"cvss"`
+}
+
+// Diagnostic printed by `cargo deny --format json`
+type denyDiagnostic struct {
+	Type   string `json:"type"`
+	Fields struct {
+		Severity string `json:"severity"`
+		Message  string `json:"message"`
+		Code     string `json:"code"`
+		Graphs   []struct {
+			Krate struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"Krate"`
+		} `json:"graphs"`
+		Labels []struct {
+			Span string `json:"span"`
+		} `json:"labels"`
+		Advisory *auditAdvisory `json:"advisory"`
+	} `json:"fields"`
+}
+
+// Check the dependencies against the RustSec advisory database with cargo audit.
+// Mount a checkout of the advisory database to run offline.
+// Fails when a finding is at or above failOn, e.g. "high", or is a
+// vulnerability without a CVSS score to rank it
+func (c *Cargo) Audit(
+	ctx context.Context,
+	// +optional
+	advisoryDb *dagger.Directory,
+	// +optional
+	failOn string,
+) ([]*Finding, error) {
+	if err := validSeverity(failOn); err != nil {
+		return nil, err
+	}
+
+	ctr, err := c.prepareProject(ctx, cargoTool("cargo-audit@"+CARGO_AUDIT_VERSION))
+	if err != nil {
+		return nil, err
+	}
+	command := []string{"cargo", "audit", "--json"}
+	if advisoryDb != nil {
+		ctr = ctr.WithMountedDirectory(ADVISORY_DB, advisoryDb)
+		command = append(command, "--db", ADVISORY_DB, "--no-fetch", "--stale")
+	}
+
+	ctr = execAllowFailureStdout(ctr, command)
+	output, exit, err := execResult(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+	var audit auditOutput
+	if err := json.Unmarshal([]byte(output), &audit); err != nil {
+		stderr, _ := ctr.File(ERROR_LOG).Contents(ctx)
+		return nil, fmt.Errorf("cargo audit exited with code %d:\n%s", exit, tail(stderr, 30))
+	}
+
+	var findings []*Finding
+	for _, v := range audit.Vulnerabilities.List {
+		findings = append(findings, auditFinding(v, "vulnerability"))
+	}
+	for kind, warnings := range audit.Warnings {
+		for _, w := range warnings {
+			findings = append(findings, auditFinding(w, kind))
+		}
+	}
+	return findings, failAbove(findings, failOn)
+}
+
+// Check licenses, bans, advisories and sources with cargo deny and the given deny.toml.
+// Fails when a finding is at or above failOn, e.g. "high", or is a
+// vulnerability without a CVSS score to rank it
+func (c *Cargo) Deny(
+	ctx context.Context,
+	config *dagger.File,
+	// +optional
+	checks []string,
+	// +optional
+	failOn string,
+) ([]*Finding, error) {
+	if err := validSeverity(failOn); err != nil {
+		return nil, err
+	}
+
+	ctr, err := c.prepareProject(ctx, cargoTool("cargo-deny@"+CARGO_DENY_VERSION))
+	if err != nil {
+		return nil, err
+	}
+	command := append([]string{"cargo", "deny", "--format", "json", "check", "--config", DENY_CONFIG}, checks...)
+	ctr = execAllowFailure(ctr.WithMountedFile(DENY_CONFIG, config), command)
+	output, exit, err := execResult(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []*Finding{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var diag denyDiagnostic
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &diag) != nil {
+			continue
+		}
+		if diag.Type != "diagnostic" || len(diag.Fields.Graphs) == 0 {
+			continue
+		}
+		findings = append(findings, denyFinding(diag))
+	}
+	if exit != 0 && len(findings) == 0 {
+		return nil, fmt.Errorf("cargo deny exited with code %d:\n%s", exit, tail(output, 30))
+	}
+	return findings, failAbove(findings, failOn)
+}
+
+func auditFinding(entry auditEntry, kind string) *Finding {
+	f := &Finding{
+		Crate:    entry.Package.Name,
+		Version:  entry.Package.Version,
+		Kind:     kind,
+		Severity: "unknown",
+	}
+	if entry.Advisory != nil {
+		f.Advisory = entry.Advisory.ID
+		f.Title = entry.Advisory.Title
+		f.Severity = cvssSeverity(entry.Advisory.Cvss)
+	}
+	return f
+}
+
+func denyFinding(diag denyDiagnostic) *Finding {
+	krate := diag.Fields.Graphs[0].Krate
+	f := &Finding{
+		Crate:   krate.Name,
+		Version: krate.Version,
+		Kind:    diag.Fields.Code,
+		Title:   diag.Fields.Message,
+	}
+	switch {
+	case diag.Fields.Advisory != nil:
+		f.Advisory = diag.Fields.Advisory.ID
+		f.Severity = cvssSeverity(diag.Fields.Advisory.Cvss)
+	case diag.Fields.Severity == "error":
+		f.Severity = "high"
+	case diag.Fields.Severity == "warning":
+		f.Severity = "medium"
+	default:
+		f.Severity = "low"
+	}
+	if strings.Contains(diag.Fields.Message, "license") && len(diag.Fields.Labels) > 0 {
+		f.Kind = "license"
+		f.License = diag.Fields.Labels[0].Span
+	}
+	return f
+}
+
+func validSeverity(severity string) error {
+	if severity == "" || severityRank(severity) >= 0 {
+		return nil
+	}
+	return fmt.Errorf("unknown severity %q, expected one of %s", severity, strings.Join(severities, ", "))
+}
+
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// Error listing the findings at or above the severity, if any. Many advisories
+// have no CVSS vector, so vulnerabilities of unknown severity always fail
+func failAbove(findings []*Finding, severity string) error {
+	if severity == "" {
+		return nil
+	}
+	var failed []string
+	for _, f := range findings {
+		unranked := f.Kind == "vulnerability" && f.Severity == "unknown"
+		if unranked || severityRank(f.Severity) >= severityRank(severity) {
+			failed = append(failed, fmt.Sprintf("%s %s: %s %s (%s) %s", f.Crate, f.Version, f.Kind, f.Advisory, f.Severity, f.Title))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d finding(s) at or above %s severity, or vulnerabilities of unknown severity:\n%s", len(failed), severity, strings.Join(failed, "\n"))
+}
+
+// Severity of a CVSS v3 vector, from its base score
+func cvssSeverity(vector string) string {
+	score, ok := cvss3Score(vector)
+	switch {
+	case !ok:
+		return "unknown"
+	case score == 0:
+		return "none"
+	case score < 4:
+		return "low"
+	case score < 7:
+		return "medium"
+	case score < 9:
+		return "high"
+	default:
+		return "critical"
+	}
+}
+
+// Base score of a CVSS v3.x vector, as specified by FIRST
+func cvss3Score(vector string) (float64, bool) {
+	if !strings.HasPrefix(vector, "CVSS:3.") {
+		return 0, false
+	}
+	m := map[string]string{}
+	for _, part := range strings.Split(vector, "/")[1:] {
+		k, v, _ := strings.Cut(part, ":")
+		m[k] = v
+	}
+
+	changed := m["S"] == "C"
+	weights := map[string]map[string]float64{
+		"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+		"AC": {"L": 0.77, "H": 0.44},
+		"PR": {"N": 0.85, "L": 0.62, "H": 0.27},
+		"UI": {"N": 0.85, "R": 0.62},
+		"C":  {"H": 0.56, "L": 0.22, "N": 0},
+		"I":  {"H": 0.56, "L": 0.22, "N": 0},
+		"A":  {"H": 0.56, "L": 0.22, "N": 0},
+	}
+	if changed {
+		weights["PR"]["L"] = 0.68
+		weights["PR"]["H"] = 0.5
+	}
+	w := map[string]float64{}
+	for metric, values := range weights {
+		v, ok := values[m[metric]]
+		if !ok {
+			return 0, false
+		}
+		w[metric] = v
+	}
+
+	iss := 1 - (1-w["C"])*(1-w["I"])*(1-w["A"])
+	impact := 6.42 * iss
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	if impact <= 0 {
+		return 0, true
+	}
+	exploitability := 8.22 * w["AV"] * w["AC"] * w["PR"] * w["UI"]
+	if changed {
+		return roundUp(math.Min(1.08*(impact+exploitability), 10)), true
+	}
+	return roundUp(math.Min(impact+exploitability, 10)), true
+}
+
+// Round up to one decimal, as specified by CVSS v3.1
+func roundUp(x float64) float64 {
+	i := int(math.Round(x * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return (math.Floor(float64(i)/10000) + 1) / 10
+}
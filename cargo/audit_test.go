@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCvss3Score(t *testing.T) {
+	tests := []struct {
+		vector   string
+		score    float64
+		severity string
+	}{
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", 9.8, "critical"},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H", 10, "critical"},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N", 6.1, "medium"},
+		{"CVSS:3.0/AV:N/AC:H/PR:N/UI:R/S:U/C:L/I:N/A:N", 3.1, "low"},
+		{"CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:N/I:N/A:N", 0, "none"},
+	}
+	for _, tt := range tests {
+		score, ok := cvss3Score(tt.vector)
+		if !ok || score != tt.score {
+			t.Errorf("%s: got %v (%v), want %v", tt.vector, score, ok, tt.score)
+		}
+		if got := cvssSeverity(tt.vector); got != tt.severity {
+			t.Errorf("%s: got severity %q, want %q", tt.vector, got, tt.severity)
+		}
+	}
+	for _, vector := range []string{"", "AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H", "CVSS:3.1/AV:X/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"} {
+		if _, ok := cvss3Score(vector); ok {
+			t.Errorf("%q: scored an invalid vector", vector)
+		}
+		if got := cvssSeverity(vector); got != "unknown" {
+			t.Errorf("%q: got severity %q, want unknown", vector, got)
+		}
+	}
+}
+
+func TestFailAbove(t *testing.T) {
+	findings := []*Finding{
+		{Crate: "a", Kind: "vulnerability", Severity: "medium"},
+		{Crate: "b", Kind: "unmaintained", Severity: "unknown"},
+	}
+	if err := failAbove(findings, ""); err != nil {
+		t.Fatalf("failed without a threshold: %v", err)
+	}
+	if err := failAbove(findings, "high"); err != nil {
+		t.Fatalf("failed below the threshold: %v", err)
+	}
+	if err := failAbove(findings, "medium"); err == nil || !strings.HasPrefix(err.Error(), "1 finding(s)") {
+		t.Fatalf("got %v, want one finding", err)
+	}
+	unknown := append(findings, &Finding{Crate: "c", Kind: "vulnerability", Severity: "unknown"})
+	if err := failAbove(unknown, "critical"); err == nil || !strings.Contains(err.Error(), "c : vulnerability") {
+		t.Fatalf("got %v, want the vulnerability of unknown severity", err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"dagger/cargo/internal/dagger"
+)
+
+// Benchmarks of a run, with the criterion output to keep as the next baseline
+type BenchReport struct {
+	Benchmarks []*Benchmark
+	// Whether no benchmark regressed beyond the threshold
+	Success     bool
+	Regressions int
+	Results     *dagger.Directory
+}
+
+// Criterion estimates for a benchmark, in nanoseconds
+type Benchmark struct {
+	ID     string
+	Mean   float64
+	Median float64
+	StdDev float64
+	// Mean of the baseline, 0 without one
+	BaselineMean float64
+	// Change of the mean against the baseline, in percent
+	Change    float64
+	Regressed bool
+}
+
+// estimates.json written by criterion
+type criterionEstimates struct {
+	Mean   criterionEstimate `json:"mean"`
+	Median criterionEstimate `json:"median"`
+	StdDev criterionEstimate `json:"std_dev"`
+}
+
+type criterionEstimate struct {
+	PointEstimate float64 `json:"point_estimate"`
+}
+
+// benchmark.json written by criterion
+type criterionBenchmark struct {
+	FullID string `json:"full_id"`
+}
+
+// Run the benchmarks with cargo bench and parse the criterion results.
+// With a baseline, the Results of a previous run, benchmarks whose mean is
+// slower by more than threshold percent are regressions. The report is returned
+// either way, failOnRegression turns regressions into an error
+func (c *Cargo) Bench(
+	ctx context.Context,
+	// +optional
+	baseline *dagger.Directory,
+	// +optional
+	// +default=10
+	threshold float64,
+	// +optional
+	failOnRegression bool,
+	// +optional
+	args []string,
+) (*BenchReport, error) {
+	ctr, err := c.prepareProject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Only this run's results, not what earlier runs left in the target cache.
+	// Timings are not a function of the inputs, so each call measures again
+	results := ctr.
+		WithEnvVariable("CARGO_BENCH_RUN", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"rm", "-rf", "target/criterion"}).
+		WithExec(append([]string{"cargo", "bench"}, args...)).
+		WithExec([]string{"sh", "-c", fmt.Sprintf("mkdir -p %[1]s/criterion && cp -a target/criterion/. %[1]s/criterion/", OUT_MOUNT)}).
+		Directory(OUT_MOUNT + "/criterion")
+
+	paths, err := results.Glob(ctx, "**/new/estimates.json")
+	if err != nil {
+		return nil, err
+	}
+	var baselinePaths []string
+	if baseline != nil {
+		if baselinePaths, err = baseline.Glob(ctx, "**/new/estimates.json"); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &BenchReport{Success: true, Results: results}
+	for _, path := range paths {
+		est, err := readEstimates(ctx, results, path)
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSuffix(path, "/new/estimates.json")
+		if content, err := results.File(id + "/new/benchmark.json").Contents(ctx); err == nil {
+			var b criterionBenchmark
+			if json.Unmarshal([]byte(content), &b) == nil && b.FullID != "" {
+				id = b.FullID
+			}
+		}
+
+		bench := &Benchmark{
+			ID:     id,
+			Mean:   est.Mean.PointEstimate,
+			Median: est.Median.PointEstimate,
+			StdDev: est.StdDev.PointEstimate,
+		}
+		if slices.Contains(baselinePaths, path) {
+			base, err := readEstimates(ctx, baseline, path)
+			if err != nil {
+				return nil, err
+			}
+			bench.BaselineMean = base.Mean.PointEstimate
+			if bench.BaselineMean > 0 {
+				bench.Change = (bench.Mean - bench.BaselineMean) / bench.BaselineMean * 100
+				bench.Regressed = bench.Change > threshold
+			}
+		}
+		if bench.Regressed {
+			report.Regressions++
+			report.Success = false
+		}
+		report.Benchmarks = append(report.Benchmarks, bench)
+	}
+
+	if report.Regressions > 0 && failOnRegression {
+		var regressed []string
+		for _, b := range report.Benchmarks {
+			if b.Regressed {
+				regressed = append(regressed, fmt.Sprintf("%s: %.0fns -> %.0fns (%+.2f%%)", b.ID, b.BaselineMean, b.Mean, b.Change))
+			}
+		}
+		return report, fmt.Errorf("%d benchmark(s) regressed by more than %.2f%%:\n%s", report.Regressions, threshold, strings.Join(regressed, "\n"))
+	}
+	return report, nil
+}
+
+func readEstimates(ctx context.Context, dir *dagger.Directory, path string) (*criterionEstimates, error) {
+	content, err := dir.File(path).Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var est criterionEstimates
+	if err := json.Unmarshal([]byte(content), &est); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &est, nil
+}
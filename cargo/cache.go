@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"dagger/cargo/internal/dagger"
+)
+
+const (
+	CARGO_REGISTRY = "/usr/local/cargo/registry"
+	CARGO_GIT      = "/usr/local/cargo/git"
+	PROJ_TARGET    = PROJ_MOUNT + "/target"
+)
+
+// Namespace the cache volumes, e.g. per repository or per branch
+func (c *Cargo) WithCacheNamespace(namespace string) *Cargo {
+	cc := *c
+	cc.CacheNamespace = namespace
+	return &cc
+}
+
+// Bring your own cache volumes. The ones not provided keep the default volume
+func (c *Cargo) WithCacheVolumes(
+	// +optional
+	registry *dagger.CacheVolume,
+	// +optional
+	git *dagger.CacheVolume,
+	// +optional
+	target *dagger.CacheVolume,
+) *Cargo {
+	cc := *c
+	if registry != nil {
+		cc.RegistryCache = registry
+	}
+	if git != nil {
+		cc.GitCache = git
+	}
+	if target != nil {
+		cc.TargetCache = target
+	}
+	return &cc
+}
+
+// Run every command cold, without mounting any cache volume
+func (c *Cargo) WithoutCache() *Cargo {
+	cc := *c
+	cc.DisableCache = true
+	return &cc
+}
+
+// Mount the registry, git and target caches on the container. The target
+// cache is only mounted for a project, and keyed by it so that the build
+// output of other projects never shows up in it
+func (c *Cargo) withCaches(ctr *dagger.Container, project string) *dagger.Container {
+	if c.DisableCache {
+		return ctr
+	}
+	ctr = ctr.
+		WithMountedCache(CARGO_REGISTRY, c.cacheVolume(c.RegistryCache, "registry", "")).
+		WithMountedCache(CARGO_GIT, c.cacheVolume(c.GitCache, "git", ""))
+	if project == "" {
+		return ctr
+	}
+	return ctr.WithMountedCache(PROJ_TARGET, c.cacheVolume(c.TargetCache, "target", project))
+}
+
+// Return the user provided volume, or one keyed by rust version, project and namespace
+func (c *Cargo) cacheVolume(volume *dagger.CacheVolume, kind, project string) *dagger.CacheVolume {
+	if volume != nil {
+		return volume
+	}
+	return dag.CacheVolume(c.cacheKey(kind, project))
+}
+
+// Key of a default cache volume. The project is empty for volumes shared by every project
+func (c *Cargo) cacheKey(kind, project string) string {
+	version := c.Version
+	if version == "" {
+		version = DEFAULT_RUST
+	}
+	key := []string{"cargo", kind, version}
+	if project != "" {
+		key = append(key, project)
+	}
+	if c.CacheNamespace != "" {
+		key = append(key, c.CacheNamespace)
+	}
+	return strings.Join(key, "-")
+}
+
+// Name of the project in cache keys: the package name, or a digest of the
+// members of a virtual workspace
+func (c *Cargo) projectName(ctx context.Context) (string, error) {
+	content, err := c.Proj.File("Cargo.toml").Contents(ctx)
+	if err != nil {
+		return "", err
+	}
+	return manifestName(content)
+}
+
+func manifestName(content string) (string, error) {
+	var manifest cargoManifest
+	if _, err := toml.Decode(content, &manifest); err != nil {
+		return "", fmt.Errorf("Cargo.toml: %w", err)
+	}
+	if manifest.Package != nil && manifest.Package.Name != "" {
+		return manifest.Package.Name, nil
+	}
+	members := slices.Clone(manifest.Workspace.Members)
+	slices.Sort(members)
+	sum := sha256.Sum256([]byte(strings.Join(members, "\n")))
+	return "workspace-" + hex.EncodeToString(sum[:6]), nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestManifestName(t *testing.T) {
+	name, err := manifestName("[package]\nname = \"app\"\nversion = \"0.1.0\"\n\n[workspace]\nmembers = [\"b\"]\n")
+	if err != nil || name != "app" {
+		t.Fatalf("got %q, %v, want app", name, err)
+	}
+	first, err := manifestName("[workspace]\nmembers = [\"a\", \"b\"]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := manifestName("[workspace]\nmembers = [\n  \"b\",\n  \"a\",\n]\n")
+	other, _ := manifestName("[workspace]\nmembers = [\"a\", \"c\"]\n")
+	if !strings.HasPrefix(first, "workspace-") || first != second || first == other {
+		t.Fatalf("workspace names %q, %q and %q", first, second, other)
+	}
+	if _, err := manifestName("[package\n"); err == nil {
+		t.Fatalf("parsed an invalid manifest")
+	}
+}
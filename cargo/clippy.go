@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"dagger/cargo/internal/dagger"
+)
+
+// Diagnostics reported by Clippy
+type ClippyReport struct {
+	Errors      int
+	Warnings    int
+	Diagnostics []*ClippyDiagnostic
+}
+
+type ClippyDiagnostic struct {
+	// Lint name, e.g. clippy::needless_return. Empty for plain compiler errors
+	Lint    string
+	Level   string
+	Message string
+	File    string
+	Line    int
+	Column  int
+	// Replacement suggested by Clippy, if any
+	Suggestion string
+	// Human readable rendering of the diagnostic
+	Rendered string
+}
+
+// Message printed by cargo with --message-format=json
+type cargoMessage struct {
+	Reason  string           `json:"reason"`
+	Message *compilerMessage `json:"message"`
+}
+
+type compilerMessage struct {
+	Message string `json:"message"`
+	Level   string `json:"level"`
+	Code    *struct {
+		Code string `json:"code"`
+	} `json:"code"`
+	Spans    []compilerSpan     `json:"spans"`
+	Children []*compilerMessage `json:"children"`
+	Rendered string             `json:"rendered"`
+}
+
+type compilerSpan struct {
+	FileName             string  `json:"file_name"`
+	LineStart            int     `json:"line_start"`
+	ColumnStart          int     `json:"column_start"`
+	IsPrimary            bool    `json:"is_primary"`
+	SuggestedReplacement *string `json:"suggested_replacement"`
+}
+
+// Lint the project. Lints in deny, warn and allow are passed to Clippy with
+// -D, -W and -A, e.g. deny=["warnings"] fails on any warning
+func (c *Cargo) Clippy(
+	ctx context.Context,
+	// +optional
+	args []string,
+	// +optional
+	deny []string,
+	// +optional
+	warn []string,
+	// +optional
+	allow []string,
+) (string, error) {
+	ctr, err := c.prepareProject(ctx)
+	if err != nil {
+		return "", err
+	}
+	command := clippyCommand([]string{"cargo", "clippy"}, args, deny, warn, allow)
+	return ctr.
+		WithExec(command).
+		Stdout(ctx)
+}
+
+// Lint the project and return the typed diagnostics.
+// Denied lints do not fail the call, check the Errors count instead
+func (c *Cargo) ClippyReport(
+	ctx context.Context,
+	// +optional
+	args []string,
+	// +optional
+	deny []string,
+	// +optional
+	warn []string,
+	// +optional
+	allow []string,
+) (*ClippyReport, error) {
+	command := clippyCommand([]string{"cargo", "clippy", "--message-format=json"}, args, deny, warn, allow)
+	ctr, err := c.prepareProject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctr = execAllowFailure(ctr, command)
+
+	output, exit, err := execResult(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+	report := parseClippy(output)
+	if exit != 0 && report.Errors == 0 {
+		return nil, fmt.Errorf("cargo clippy exited with code %d:\n%s", exit, tail(output, 30))
+	}
+	return report, nil
+}
+
+// Export the diagnostics as a SARIF 2.1.0 file for code scanning tools
+func (r *ClippyReport) Sarif() *dagger.File {
+	type region struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn,omitempty"`
+	}
+	type location struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI string `json:"uri"`
+			} `json:"artifactLocation"`
+			Region region `json:"region"`
+		} `json:"physicalLocation"`
+	}
+	type message struct {
+		Text string `json:"text"`
+	}
+	type result struct {
+		RuleID    string     `json:"ruleId,omitempty"`
+		Level     string     `json:"level"`
+		Message   message    `json:"message"`
+		Locations []location `json:"locations"`
+	}
+	type rule struct {
+		ID string `json:"id"`
+	}
+
+	rules := []rule{}
+	seen := map[string]bool{}
+	results := []result{}
+	for _, d := range r.Diagnostics {
+		if d.Lint != "" && !seen[d.Lint] {
+			seen[d.Lint] = true
+			rules = append(rules, rule{ID: d.Lint})
+		}
+		var loc location
+		loc.PhysicalLocation.ArtifactLocation.URI = d.File
+		loc.PhysicalLocation.Region = region{StartLine: d.Line, StartColumn: d.Column}
+		results = append(results, result{
+			RuleID:    d.Lint,
+			Level:     sarifLevel(d.Level),
+			Message:   message{Text: d.Message},
+			Locations: []location{loc},
+		})
+	}
+
+	doc := map[string]any{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []any{map[string]any{
+			"tool": map[string]any{"driver": map[string]any{
+				"name":           "clippy",
+				"informationUri": "https://rust-lang.github.io/rust-clippy/",
+				"rules":          rules,
+			}},
+			"results": results,
+		}},
+	}
+	// Marshalling only fails on unsupported types, which the document does not contain
+	out, _ := json.MarshalIndent(doc, "", "  ")
+	return dag.Directory().
+		WithNewFile("clippy.sarif", string(out)).
+		File("clippy.sarif")
+}
+
+// Append the user args and the lint levels, which go to clippy-driver after "--"
+func clippyCommand(command, args, deny, warn, allow []string) []string {
+	command = append(command, args...)
+	if len(deny)+len(warn)+len(allow) == 0 {
+		return command
+	}
+	if !slices.Contains(args, "--") {
+		command = append(command, "--")
+	}
+	for _, lint := range deny {
+		command = append(command, "-D", lint)
+	}
+	for _, lint := range warn {
+		command = append(command, "-W", lint)
+	}
+	for _, lint := range allow {
+		command = append(command, "-A", lint)
+	}
+	return command
+}
+
+// Parse cargo JSON messages, keeping only the ones pointing at a location
+func parseClippy(output string) *ClippyReport {
+	report := &ClippyReport{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg cargoMessage
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &msg) != nil {
+			continue
+		}
+		if msg.Reason != "compiler-message" || msg.Message == nil {
+			continue
+		}
+		d := toDiagnostic(msg.Message)
+		if d == nil {
+			continue
+		}
+		switch d.Level {
+		case "error":
+			report.Errors++
+		case "warning":
+			report.Warnings++
+		}
+		report.Diagnostics = append(report.Diagnostics, d)
+	}
+	return report
+}
+
+func toDiagnostic(m *compilerMessage) *ClippyDiagnostic {
+	var primary *compilerSpan
+	for i := range m.Spans {
+		if m.Spans[i].IsPrimary {
+			primary = &m.Spans[i]
+			break
+		}
+	}
+	if primary == nil {
+		return nil
+	}
+
+	d := &ClippyDiagnostic{
+		Level:    m.Level,
+		Message:  m.Message,
+		File:     primary.FileName,
+		Line:     primary.LineStart,
+		Column:   primary.ColumnStart,
+		Rendered: m.Rendered,
+	}
+	if m.Code != nil {
+		d.Lint = m.Code.Code
+	}
+	for _, child := range m.Children {
+		for _, span := range child.Spans {
+			if span.SuggestedReplacement != nil {
+				d.Suggestion = *span.SuggestedReplacement
+				return d
+			}
+		}
+	}
+	return d
+}
+
+func sarifLevel(level string) string {
+	switch level {
+	case "error", "error: internal compiler error":
+		return "error"
+	case "warning":
+		return "warning"
+	default:
+		return "note"
+	}
+}
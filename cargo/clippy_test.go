@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseClippy(t *testing.T) {
+	output := `{"reason":"compiler-artifact","target":{"kind":["lib"]}}
+{"reason":"compiler-message","message":{"message":"unneeded ` + "`return`" + ` statement","level":"warning","code":{"code":"clippy::needless_return"},"spans":[{"file_name":"src/lib.rs","line_start":3,"column_start":5,"is_primary":true}],"children":[{"message":"remove ` + "`return`" + `","level":"help","spans":[{"file_name":"src/lib.rs","line_start":3,"column_start":5,"is_primary":true,"suggested_replacement":"x"}]}],"rendered":"warning: unneeded return"}}
+{"reason":"compiler-message","message":{"message":"mismatched types","level":"error","code":{"code":"E0308"},"spans":[{"file_name":"src/main.rs","line_start":7,"column_start":9,"is_primary":false},{"file_name":"src/main.rs","line_start":8,"column_start":1,"is_primary":true}],"children":[]}}
+{"reason":"compiler-message","message":{"message":"aborting due to 1 previous error","level":"error","spans":[],"children":[]}}
+warning: build failed, waiting for other jobs to finish...
+{"reason":"build-finished","success":false}
+`
+	report := parseClippy(output)
+	if report.Warnings != 1 || report.Errors != 1 || len(report.Diagnostics) != 2 {
+		t.Fatalf("got %d warnings, %d errors, %d diagnostics", report.Warnings, report.Errors, len(report.Diagnostics))
+	}
+	lint := report.Diagnostics[0]
+	if lint.Lint != "clippy::needless_return" || lint.File != "src/lib.rs" || lint.Line != 3 || lint.Column != 5 || lint.Suggestion != "x" {
+		t.Fatalf("lint %+v", *lint)
+	}
+	if e := report.Diagnostics[1]; e.Lint != "E0308" || e.Line != 8 || e.Column != 1 {
+		t.Fatalf("error %+v", *e)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"dagger/cargo/internal/dagger"
+)
+
+const CARGO_LLVM_COV_VERSION = "0.6.16"
+
+// Coverage of a test run, with the reports to upload
+type CoverageReport struct {
+	LinePercent float64
+	// Only measured with branch, nil otherwise
+	BranchPercent   *float64
+	FunctionPercent float64
+	RegionPercent   float64
+	Lcov            *dagger.File
+	Cobertura       *dagger.File
+}
+
+// Summary written by `cargo llvm-cov report --json --summary-only`
+type llvmCovSummary struct {
+	Data []struct {
+		Totals map[string]struct {
+			Count   int     `json:"count"`
+			Covered int     `json:"covered"`
+			Percent float64 `json:"percent"`
+		} `json:"totals"`
+	} `json:"data"`
+}
+
+// Run the tests instrumented with cargo llvm-cov and return LCOV and Cobertura
+// reports. Fails when line coverage is below minLines, in percent. Branch
+// coverage is only instrumented with branch, which needs a nightly toolchain
+func (c *Cargo) Coverage(
+	ctx context.Context,
+	// +optional
+	args []string,
+	// +optional
+	minLines float64,
+	// Measure branch coverage with --branch
+	// +optional
+	branch bool,
+) (*CoverageReport, error) {
+	ctr, err := c.prepareProject(ctx, rustupComponent("llvm-tools-preview"), cargoTool("cargo-llvm-cov@"+CARGO_LLVM_COV_VERSION))
+	if err != nil {
+		return nil, err
+	}
+	llvmCov := []string{"cargo", "llvm-cov"}
+	if branch {
+		llvmCov = append(llvmCov, "--branch")
+	}
+	report := func(format, file string) []string {
+		return append(slices.Clone(llvmCov), "report", format, "--output-path", OUT_MOUNT+"/"+file)
+	}
+	ctr = ctr.
+		WithExec(append(append(slices.Clone(llvmCov), "--no-report"), args...)).
+		WithExec([]string{"mkdir", "-p", OUT_MOUNT}).
+		WithExec(report("--lcov", "lcov.info")).
+		WithExec(report("--cobertura", "cobertura.xml")).
+		WithExec(append(report("--json", "summary.json"), "--summary-only"))
+
+	out, err := ctr.File(OUT_MOUNT + "/summary.json").Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var summary llvmCovSummary
+	if err := json.Unmarshal([]byte(out), &summary); err != nil {
+		return nil, err
+	}
+	if len(summary.Data) == 0 {
+		return nil, fmt.Errorf("no coverage data in llvm-cov summary")
+	}
+
+	totals := summary.Data[0].Totals
+	coverage := &CoverageReport{
+		LinePercent:     totals["lines"].Percent,
+		FunctionPercent: totals["functions"].Percent,
+		RegionPercent:   totals["regions"].Percent,
+		Lcov:            ctr.File(OUT_MOUNT + "/lcov.info"),
+		Cobertura:       ctr.File(OUT_MOUNT + "/cobertura.xml"),
+	}
+	if branch {
+		percent := totals["branches"].Percent
+		coverage.BranchPercent = &percent
+	}
+	if coverage.LinePercent < minLines {
+		return coverage, fmt.Errorf("line coverage %.2f%% is below the minimum of %.2f%%", coverage.LinePercent, minLines)
+	}
+	return coverage, nil
+}
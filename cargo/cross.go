@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+
+	"dagger/cargo/internal/dagger"
+)
+
+// Toolchain needed on a Debian based rust image to link for a target triple
+type crossToolchain struct {
+	packages []string
+	linker   string
+}
+
+// Known target triples. Triples missing from this table are only added with
+// rustup and use the default linker; bring your own container for those.
+var crossToolchains = map[string]crossToolchain{
+	"x86_64-unknown-linux-gnu": {
+		packages: []string{"gcc-x86-64-linux-gnu", "libc6-dev-amd64-cross"},
+		linker:   "x86_64-linux-gnu-gcc",
+	},
+	"aarch64-unknown-linux-gnu": {
+		packages: []string{"gcc-aarch64-linux-gnu", "libc6-dev-arm64-cross"},
+		linker:   "aarch64-linux-gnu-gcc",
+	},
+	"x86_64-unknown-linux-musl": {
+		packages: []string{"musl-tools"},
+	},
+	"aarch64-unknown-linux-musl": {
+		packages: []string{"gcc-aarch64-linux-gnu"},
+		linker:   "aarch64-linux-gnu-gcc",
+	},
+}
+
+// Build the project in release mode for each target triple.
+// The returned Directory is laid out as <triple>/<binary>
+func (c *Cargo) BuildTargets(
+	ctx context.Context,
+	targets []string,
+	// +optional
+	args []string,
+) (*dagger.Directory, error) {
+	ctr, err := c.prepareProject(ctx, crossTools(targets...)...)
+	if err != nil {
+		return nil, err
+	}
+	bins := make([]*dagger.Directory, len(targets))
+	eg, gctx := errgroup.WithContext(ctx)
+	for i, target := range targets {
+		i, target := i, target
+		eg.Go(func() error {
+			command := append([]string{"cargo", "build", "--release", "--target", target}, args...)
+			dir, err := buildArtifacts(gctx, withCrossTarget(ctr, target), command, executables)
+			bins[i] = dir
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	out := dag.Directory()
+	for i, target := range targets {
+		out = out.WithDirectory(target, bins[i])
+	}
+	return out, nil
+}
+
+// Steps adding the rust targets and installing their linkers, before the
+// project is mounted so that source changes do not install them again
+func crossTools(targets ...string) []tool {
+	var tools []tool
+	var packages []string
+	for _, target := range targets {
+		tools = append(tools, rustupTarget(target))
+		packages = append(packages, crossToolchains[target].packages...)
+	}
+	if len(packages) > 0 {
+		slices.Sort(packages)
+		tools = append(tools, aptPackages(slices.Compact(packages)...))
+	}
+	return tools
+}
+
+// Configure the linker for a target installed by crossTools
+func withCrossTarget(ctr *dagger.Container, target string) *dagger.Container {
+	tc := crossToolchains[target]
+	if tc.linker != "" {
+		env := strings.ToUpper(strings.ReplaceAll(target, "-", "_"))
+		ctr = ctr.
+			WithEnvVariable("CARGO_TARGET_"+env+"_LINKER", tc.linker).
+			WithEnvVariable("CC_"+strings.ReplaceAll(target, "-", "_"), tc.linker)
+	}
+	return ctr
+}
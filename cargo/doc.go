@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"dagger/cargo/internal/dagger"
+)
+
+// Doc tests and intra-doc link findings
+type DocReport struct {
+	DocTests    *TestReport
+	BrokenLinks []*DocFinding
+}
+
+type DocFinding struct {
+	// rustdoc::broken_intra_doc_links or rustdoc::private_intra_doc_links
+	Lint    string
+	Message string
+	File    string
+	Line    int
+	Column  int
+}
+
+// Build the documentation with cargo doc --no-deps and return target/doc,
+// ready to serve or publish. With denyWarnings, any rustdoc warning fails the build
+func (c *Cargo) Doc(
+	ctx context.Context,
+	// +optional
+	documentPrivateItems bool,
+	// +optional
+	denyWarnings bool,
+	// +optional
+	args []string,
+) (*dagger.Directory, error) {
+	ctr, err := c.prepareProject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	command := []string{"cargo", "doc", "--no-deps"}
+	if documentPrivateItems {
+		command = append(command, "--document-private-items")
+	}
+	if denyWarnings {
+		ctr = ctr.WithEnvVariable("RUSTDOCFLAGS", "-D warnings")
+	}
+	// Only this build's documentation, not what earlier builds left in the target cache
+	return ctr.
+		WithExec([]string{"rm", "-rf", "target/doc"}).
+		WithExec(append(command, args...)).
+		WithExec([]string{"sh", "-c", fmt.Sprintf("mkdir -p %[1]s/doc && cp -a target/doc/. %[1]s/doc/", OUT_MOUNT)}).
+		Directory(OUT_MOUNT + "/doc"), nil
+}
+
+// Run the doc tests, if there is a library, and report broken intra-doc links
+func (c *Cargo) DocCheck(
+	ctx context.Context,
+	// +optional
+	documentPrivateItems bool,
+) (*DocReport, error) {
+	ctr, err := c.prepareProject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Doc tests only exist for libraries, cargo test --doc fails without one
+	meta, err := metadata(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+	tests := &TestReport{}
+	if slices.ContainsFunc(meta.Packages, cargoPackage.hasLib) {
+		if tests, err = c.TestReport(ctx, []string{"--doc"}); err != nil {
+			return nil, err
+		}
+	}
+	command := []string{"cargo", "doc", "--no-deps", "--message-format=json"}
+	if documentPrivateItems {
+		command = append(command, "--document-private-items")
+	}
+	ctr = execAllowFailureStdout(ctr, command)
+	output, exit, err := execResult(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DocReport{DocTests: tests}
+	for _, d := range parseClippy(output).Diagnostics {
+		if !strings.HasSuffix(d.Lint, "_intra_doc_links") {
+			continue
+		}
+		report.BrokenLinks = append(report.BrokenLinks, &DocFinding{
+			Lint:    d.Lint,
+			Message: d.Message,
+			File:    d.File,
+			Line:    d.Line,
+			Column:  d.Column,
+		})
+	}
+	// Links denied by the crate fail the build, anything else is unexpected
+	if exit != 0 && len(report.BrokenLinks) == 0 {
+		stderr, _ := ctr.File(ERROR_LOG).Contents(ctx)
+		return nil, fmt.Errorf("cargo doc exited with code %d:\n%s", exit, tail(stderr, 30))
+	}
+	return report, nil
+}
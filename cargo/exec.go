@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"dagger/cargo/internal/dagger"
+)
+
+const (
+	OUTPUT_LOG = "/tmp/cargo.log"
+	ERROR_LOG  = "/tmp/cargo.err"
+	EXIT_CODE  = "/tmp/cargo.exit"
+	DURATION   = "/tmp/cargo.duration"
+)
+
+// Run a command without failing the pipeline on a non-zero exit code.
+// The combined stdout/stderr is written to OUTPUT_LOG and the exit code to EXIT_CODE
+func execAllowFailure(ctr *dagger.Container, command []string) *dagger.Container {
+	script := `"$@" > ` + OUTPUT_LOG + ` 2>&1; echo $? > ` + EXIT_CODE
+	return ctr.WithExec(append([]string{"sh", "-c", script, "sh"}, command...))
+}
+
+// Same as execAllowFailure, but keeps stderr apart in ERROR_LOG for commands
+// whose stdout is meant to be parsed
+func execAllowFailureStdout(ctr *dagger.Container, command []string) *dagger.Container {
+	script := `"$@" > ` + OUTPUT_LOG + ` 2> ` + ERROR_LOG + `; echo $? > ` + EXIT_CODE
+	return ctr.WithExec(append([]string{"sh", "-c", script, "sh"}, command...))
+}
+
+// Wrap a command to record its wall time in milliseconds to DURATION
+func timed(command []string) []string {
+	script := `start=$(date +%s%N); "$@"; code=$?; echo $(( ($(date +%s%N) - start) / 1000000 )) > ` + DURATION + `; exit $code`
+	return append([]string{"sh", "-c", script, "sh"}, command...)
+}
+
+// Read the log and exit code left by execAllowFailure
+func execResult(ctx context.Context, ctr *dagger.Container) (string, int, error) {
+	output, err := ctr.File(OUTPUT_LOG).Contents(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	code, err := ctr.File(EXIT_CODE).Contents(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	exit, err := strconv.Atoi(strings.TrimSpace(code))
+	if err != nil {
+		return "", 0, err
+	}
+	return output, exit, nil
+}
+
+// Last lines of a log, to keep error messages readable
+func tail(log string, lines int) string {
+	split := strings.Split(strings.TrimRight(log, "\n"), "\n")
+	if len(split) > lines {
+		split = split[len(split)-lines:]
+	}
+	return strings.Join(split, "\n")
+}
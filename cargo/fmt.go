@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dagger/cargo/internal/dagger"
+)
+
+// Where the original and formatted projects are mounted to diff them
+const FMT_DIFF = "/fmt"
+
+// Format the project and return the formatted Directory
+func (c *Cargo) FmtFix(
+	ctx context.Context,
+	// +optional
+	args []string,
+) (*dagger.Directory, error) {
+	ctr, err := c.prepareProject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	command := append([]string{"cargo", "fmt"}, args...)
+	return ctr.WithExec(command).Directory(PROJ_MOUNT), nil
+}
+
+// Check the project formatting. Fails with the list of unformatted files and
+// a unified diff that applies with `patch -p1`
+func (c *Cargo) FmtCheck(
+	ctx context.Context,
+	// +optional
+	args []string,
+) (string, error) {
+	ctr, err := c.prepareProject(ctx)
+	if err != nil {
+		return "", err
+	}
+	command := append([]string{"cargo", "fmt"}, args...)
+	formatted := ctr.WithExec(command).Directory(PROJ_MOUNT)
+
+	diff := execAllowFailure(
+		ctr.
+			WithMountedDirectory(FMT_DIFF+"/a", c.Proj).
+			WithMountedDirectory(FMT_DIFF+"/b", formatted).
+			WithWorkdir(FMT_DIFF),
+		[]string{"diff", "-ruN", "-x", "target", "a", "b"},
+	)
+	patch, exit, err := execResult(ctx, diff)
+	if err != nil {
+		return "", err
+	}
+
+	switch exit {
+	case 0:
+		return "project is formatted", nil
+	case 1:
+		files := patchFiles(patch)
+		return "", fmt.Errorf("%d file(s) not formatted:\n%s\n\n%s", len(files), strings.Join(files, "\n"), patch)
+	default:
+		return "", fmt.Errorf("diff exited with code %d:\n%s", exit, tail(patch, 30))
+	}
+}
+
+// Files changed in a unified diff, relative to the project root
+func patchFiles(patch string) []string {
+	var files []string
+	for _, line := range strings.Split(patch, "\n") {
+		if file, ok := strings.CutPrefix(line, "+++ b/"); ok {
+			file, _, _ = strings.Cut(file, "\t")
+			files = append(files, file)
+		}
+	}
+	return files
+}
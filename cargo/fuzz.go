@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+
+	"dagger/cargo/internal/dagger"
+)
+
+const (
+	FUZZ_CORPUS        = "/fuzz-corpus"
+	CARGO_FUZZ_VERSION = "0.12.0"
+)
+
+// Outcome of a fuzzing run
+type FuzzReport struct {
+	Crashed bool
+	// Crash, oom and timeout inputs found by libFuzzer, and their minimized versions
+	Artifacts *dagger.Directory
+	// Names of the minimized reproducers in Artifacts
+	Reproducers []string
+	// libFuzzer seed of the run, to reproduce it
+	Seed   int
+	Output string
+}
+
+// Fuzz a cargo-fuzz target for duration seconds on a nightly toolchain. The
+// corpus persists across runs in a cache volume per project, and crashing
+// inputs are minimized with cargo fuzz tmin
+func (c *Cargo) Fuzz(
+	ctx context.Context,
+	target string,
+	// +optional
+	// +default=60
+	duration int,
+	// libFuzzer seed, random by default so that each call fuzzes again
+	// +optional
+	seed int,
+	// +optional
+	args []string,
+) (*FuzzReport, error) {
+	if err := c.validate(ctx); err != nil {
+		return nil, err
+	}
+	if duration <= 0 {
+		duration = 60
+	}
+	if seed <= 0 {
+		seed = int(rand.Int32N(math.MaxInt32)) + 1
+	}
+	project, err := c.projectName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nightly := c
+	if !strings.HasPrefix(c.Version, "nightly") {
+		nightly = c.Base("nightly")
+	}
+	// New inputs go to the cached corpus, seeded by the one checked in the project
+	corpus := "fuzz/corpus/" + target
+	artifacts := "fuzz/artifacts/" + target
+	ctr, err := nightly.prepare(ctx, cargoTool("cargo-fuzz@"+CARGO_FUZZ_VERSION))
+	if err != nil {
+		return nil, err
+	}
+	ctr = ctr.
+		WithMountedCache(FUZZ_CORPUS, nightly.cacheVolume(nil, "fuzz-corpus-"+target, project)).
+		WithExec([]string{"mkdir", "-p", corpus, artifacts})
+
+	command := append([]string{"cargo", "fuzz", "run", target, FUZZ_CORPUS, corpus}, args...)
+	command = append(command, "--", fmt.Sprintf("-max_total_time=%d", duration), fmt.Sprintf("-seed=%d", seed))
+	ctr = execAllowFailure(ctr, pinToolchain(command))
+	output, exit, err := execResult(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+
+	minimize := fmt.Sprintf(
+		`for f in %[1]s/crash-* %[1]s/oom-* %[1]s/timeout-*; do [ -f "$f" ] && cargo fuzz tmin %[2]s "$f" || true; done`,
+		artifacts, target,
+	)
+	ctr = ctr.WithExec(pinToolchain([]string{"sh", "-c", minimize}))
+	entries, err := ctr.Directory(artifacts).Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FuzzReport{Artifacts: ctr.Directory(artifacts), Seed: seed, Output: tail(output, 50)}
+	for _, entry := range entries {
+		switch {
+		case strings.HasPrefix(entry, "minimized-from-"):
+			report.Reproducers = append(report.Reproducers, entry)
+		case strings.HasPrefix(entry, "crash-"), strings.HasPrefix(entry, "oom-"), strings.HasPrefix(entry, "timeout-"):
+			report.Crashed = true
+		}
+	}
+	if exit != 0 && !report.Crashed {
+		return nil, fmt.Errorf("cargo fuzz exited with code %d:\n%s", exit, tail(output, 30))
+	}
+	return report, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/exp/maps"
+
+	"dagger/cargo/internal/dagger"
+)
+
+const (
+	// Same Debian release as the default rust image, so that binaries linked
+	// against its glibc start
+	DISTROLESS_IMAGE = "gcr.io/distroless/cc-debian13"
+	ALPINE_IMAGE     = "alpine:3"
+)
+
+// Build a binary in release mode and return a minimal runtime Container with
+// just that binary as entrypoint, labelled from the Cargo.toml metadata.
+// The base is distroless, alpine, scratch or any image reference; alpine and
+// scratch get a static musl build. Defaults to the only binary of the project
+func (c *Cargo) RuntimeContainer(
+	ctx context.Context,
+	// +optional
+	binary string,
+	// +optional
+	// +default="distroless"
+	base string,
+) (*dagger.Container, error) {
+	// alpine and scratch get a static build, for the musl variant of the host
+	if err := c.validate(ctx); err != nil {
+		return nil, err
+	}
+	var target string
+	var tools []tool
+	if base == "alpine" || base == "scratch" {
+		host, err := hostTriple(ctx, c.base())
+		if err != nil {
+			return nil, err
+		}
+		target = strings.Replace(host, "-gnu", "-musl", 1)
+		tools = crossTools(target)
+	}
+	ctr, err := c.prepareProject(ctx, tools...)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := metadata(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+	pkg, binary, err := findBinary(meta, binary)
+	if err != nil {
+		return nil, err
+	}
+
+	var runtime *dagger.Container
+	switch base {
+	case "", "distroless":
+		runtime = dag.Container().From(DISTROLESS_IMAGE)
+	case "alpine":
+		runtime = dag.Container().From(ALPINE_IMAGE)
+	case "scratch":
+		runtime = dag.Container()
+	default:
+		runtime = dag.Container().From(base)
+	}
+
+	command := []string{"cargo", "build", "--release", "--bin", binary}
+	path := "target/release/" + binary
+	if target != "" {
+		ctr = withCrossTarget(ctr, target)
+		command = append(command, "--target", target)
+		path = fmt.Sprintf("target/%s/release/%s", target, binary)
+	}
+	// Copied in the same exec as the build, since the target cache is shared
+	// with other builds that could replace the binary
+	script := fmt.Sprintf(`"$@" && mkdir -p %[1]s && cp %[2]s %[1]s/`, OUT_MOUNT, path)
+	bin := ctr.
+		WithEnvVariable("CARGO_PROFILE_RELEASE_STRIP", "symbols").
+		WithExec(append([]string{"sh", "-c", script, "sh"}, command...)).
+		File(OUT_MOUNT + "/" + binary)
+
+	runtime = runtime.
+		WithFile("/usr/local/bin/"+binary, bin, dagger.ContainerWithFileOpts{Permissions: 0755}).
+		WithEntrypoint([]string{"/usr/local/bin/" + binary})
+	labels := ociLabels(pkg)
+	// Sorted so the pipeline is the same on every call
+	names := maps.Keys(labels)
+	slices.Sort(names)
+	for _, name := range names {
+		if labels[name] != "" {
+			runtime = runtime.WithLabel(name, labels[name])
+		}
+	}
+	return runtime, nil
+}
+
+// Find the package providing the binary, or the only binary of the workspace
+func findBinary(meta *cargoMetadata, binary string) (cargoPackage, string, error) {
+	var found []string
+	for _, pkg := range meta.Packages {
+		for _, bin := range pkg.bins() {
+			if bin == binary {
+				return pkg, bin, nil
+			}
+			found = append(found, bin)
+		}
+	}
+	if binary == "" && len(found) == 1 {
+		for _, pkg := range meta.Packages {
+			if len(pkg.bins()) == 1 {
+				return pkg, found[0], nil
+			}
+		}
+	}
+	if binary == "" {
+		return cargoPackage{}, "", fmt.Errorf("project has %d binaries, pick one of: %s", len(found), strings.Join(found, ", "))
+	}
+	return cargoPackage{}, "", fmt.Errorf("binary %q not found, project has: %s", binary, strings.Join(found, ", "))
+}
+
+// OCI annotations populated from the package metadata
+func ociLabels(pkg cargoPackage) map[string]string {
+	return map[string]string{
+		"org.opencontainers.image.title":       pkg.Name,
+		"org.opencontainers.image.version":     pkg.Version,
+		"org.opencontainers.image.description": pkg.Description,
+		"org.opencontainers.image.licenses":    pkg.License,
+		"org.opencontainers.image.source":      pkg.Repository,
+		"org.opencontainers.image.url":         pkg.Homepage,
+		"org.opencontainers.image.authors":     strings.Join(pkg.Authors, ", "),
+	}
+}
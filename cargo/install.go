@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"slices"
+
+	"dagger/cargo/internal/dagger"
+)
+
+const (
+	CARGO_BIN     = "/usr/local/cargo/bin"
+	INSTALL_MOUNT = "/install"
+	// Toolchain of the DEFAULT_RUST image, already installed there
+	TOOLS_TOOLCHAIN  = "1.90.0"
+	BINSTALL_VERSION = "1.10.0"
+)
+
+// A tool added with Install, installed on every container the module derives
+type InstalledTool struct {
+	// cargo install or cargo binstall command line
+	Command []string
+	// Mounted at INSTALL_MOUNT, for installs from a local path
+	Path     *dagger.Directory
+	Binstall bool
+}
+
+// Install a tool and return the Cargo with it on PATH. The source is crates.io
+// by default, with an optional version requirement; a git repository at a tag,
+// rev or branch; or a local path. With binstall, prebuilt binaries are
+// downloaded from crates.io metadata instead of compiling
+func (c *Cargo) Install(
+	// Crate to install. Optional with a path or a git repository holding a single crate
+	// +optional
+	crate string,
+	// Version requirement for crates.io, e.g. ^1.2
+	// +optional
+	version string,
+	// +optional
+	git string,
+	// +optional
+	tag string,
+	// +optional
+	rev string,
+	// +optional
+	branch string,
+	// +optional
+	path *dagger.Directory,
+	// Binaries to install, all of them by default
+	// +optional
+	bins []string,
+	// +optional
+	binstall bool,
+) (*Cargo, error) {
+	command, err := installCommand(crate, version, git, tag, rev, branch, path != nil, bins, binstall)
+	if err != nil {
+		return nil, err
+	}
+	return c.withTool(&InstalledTool{Command: command, Path: path, Binstall: binstall}), nil
+}
+
+// Install a package from a git repository
+func (c *Cargo) InstallFromGit(url string, branch string, bin string, pkg string) *Cargo {
+	command := []string{"cargo", "install", "--git", url, "--branch", branch, "--bin", bin, pkg}
+	return c.withTool(&InstalledTool{Command: command})
+}
+
+// Export a binary installed with Install or InstallFromGit
+func (c *Cargo) InstalledBinary(name string) *dagger.File {
+	return c.withTools(c.base()).File(CARGO_BIN + "/" + name)
+}
+
+// Add a tool without touching the receiver's list
+func (c *Cargo) withTool(t *InstalledTool) *Cargo {
+	cc := *c
+	cc.Tools = append(slices.Clip(c.Tools), t)
+	return &cc
+}
+
+// Install the tools added with Install on the container. They are kept apart
+// from the container, so that Base and WithContainer keep them whatever the
+// call order
+func (c *Cargo) withTools(ctr *dagger.Container) *dagger.Container {
+	for _, t := range c.Tools {
+		if t.Binstall {
+			ctr = cargoTool("cargo-binstall@" + BINSTALL_VERSION)(ctr)
+		}
+		if t.Path != nil {
+			ctr = ctr.WithMountedDirectory(INSTALL_MOUNT, t.Path)
+		}
+		ctr = ctr.WithExec(t.Command)
+		if t.Path != nil {
+			ctr = ctr.WithoutMount(INSTALL_MOUNT)
+		}
+	}
+	return ctr
+}
+
+// Command line of cargo install or cargo binstall for the given source
+func installCommand(crate, version, git, tag, rev, branch string, path bool, bins []string, binstall bool) ([]string, error) {
+	refs := 0
+	for _, ref := range []string{tag, rev, branch} {
+		if ref != "" {
+			refs++
+		}
+	}
+	switch {
+	case refs > 1:
+		return nil, errors.New("only one of tag, rev and branch can be set")
+	case refs > 0 && git == "":
+		return nil, errors.New("tag, rev and branch require a git repository")
+	case git != "" && path:
+		return nil, errors.New("only one of git and path can be set")
+	case version != "" && (git != "" || path):
+		return nil, errors.New("a version requirement only applies to crates.io")
+	case crate == "" && git == "" && !path:
+		return nil, errors.New("a crate, git repository or path is required")
+	case binstall && (git != "" || path):
+		return nil, errors.New("binstall only installs from crates.io")
+	}
+
+	if binstall {
+		spec := crate
+		if version != "" {
+			spec += "@" + version
+		}
+		return []string{"cargo", "binstall", "--no-confirm", spec}, nil
+	}
+
+	command := []string{"cargo", "install", "--locked"}
+	switch {
+	case git != "":
+		command = append(command, "--git", git)
+		if tag != "" {
+			command = append(command, "--tag", tag)
+		}
+		if rev != "" {
+			command = append(command, "--rev", rev)
+		}
+		if branch != "" {
+			command = append(command, "--branch", branch)
+		}
+	case path:
+		command = append(command, "--path", INSTALL_MOUNT)
+	case version != "":
+		command = append(command, "--version", version)
+	}
+	for _, bin := range bins {
+		command = append(command, "--bin", bin)
+	}
+	if crate != "" {
+		command = append(command, crate)
+	}
+	return command, nil
+}
+
+// Step installing a tool needed by a command, see prepare
+type tool func(*dagger.Container) *dagger.Container
+
+// Install a cargo subcommand from crates.io, e.g. cargo-audit or wasm-bindgen-cli@0.2.92.
+// Tools are compiled with TOOLS_TOOLCHAIN, since the toolchain pinned by the
+// project or under test can be too old for them. With --locked, the versions of
+// the crate's own Cargo.lock are built instead of the latest compatible ones;
+// cargo checks every download against the index checksum either way
+func cargoTool(crate string) tool {
+	return func(ctr *dagger.Container) *dagger.Container {
+		return ctr.
+			WithExec([]string{"rustup", "toolchain", "install", "--profile", "minimal", TOOLS_TOOLCHAIN}).
+			WithExec([]string{"cargo", "+" + TOOLS_TOOLCHAIN, "install", "--locked", crate})
+	}
+}
+
+// Add a component to the active toolchain
+func rustupComponent(component string) tool {
+	return func(ctr *dagger.Container) *dagger.Container {
+		return ctr.WithExec([]string{"rustup", "component", "add", component})
+	}
+}
+
+// Install Debian packages, e.g. linkers or archivers
+func aptPackages(packages ...string) tool {
+	return func(ctr *dagger.Container) *dagger.Container {
+		return ctr.
+			WithExec([]string{"apt-get", "update"}).
+			WithExec(append([]string{"apt-get", "install", "-y", "--no-install-recommends"}, packages...))
+	}
+}
+
+// Add a compilation target to the active toolchain
+func rustupTarget(target string) tool {
+	return func(ctr *dagger.Container) *dagger.Container {
+		return ctr.WithExec([]string{"rustup", "target", "add", target})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInstallCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		crate    string
+		version  string
+		git      string
+		tag      string
+		rev      string
+		branch   string
+		path     bool
+		bins     []string
+		binstall bool
+		want     string
+		err      bool
+	}{
+		{name: "crates.io", crate: "ripgrep", want: "cargo install --locked ripgrep"},
+		{name: "version", crate: "ripgrep", version: "^14", bins: []string{"rg"}, want: "cargo install --locked --version ^14 --bin rg ripgrep"},
+		{name: "git tag", git: "https://example.com/repo.git", tag: "v1", want: "cargo install --locked --git https://example.com/repo.git --tag v1"},
+		{name: "path", crate: "tool", path: true, want: "cargo install --locked --path " + INSTALL_MOUNT + " tool"},
+		{name: "binstall", crate: "ripgrep", version: "14.1.0", binstall: true, want: "cargo binstall --no-confirm ripgrep@14.1.0"},
+		{name: "two refs", git: "https://example.com/repo.git", tag: "v1", rev: "abc", err: true},
+		{name: "ref without git", crate: "tool", branch: "main", err: true},
+		{name: "git and path", git: "https://example.com/repo.git", path: true, err: true},
+		{name: "version with git", git: "https://example.com/repo.git", version: "1", err: true},
+		{name: "no source", err: true},
+		{name: "binstall from git", git: "https://example.com/repo.git", binstall: true, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, err := installCommand(tt.crate, tt.version, tt.git, tt.tag, tt.rev, tt.branch, tt.path, tt.bins, tt.binstall)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("got %q, want an error", command)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(command, " "); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
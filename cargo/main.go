@@ -2,97 +2,212 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"dagger/cargo/internal/dagger"
 )
 
 const (
-	DEFAULT_RUST = "1.73"
+	DEFAULT_RUST = "1.90"
 	PROJ_MOUNT   = "/src"
+	OUT_MOUNT    = "/out"
+)
+
+var (
+	ErrNoProject  = errors.New("no project configured, pass one to the constructor or use WithProject")
+	ErrNoManifest = errors.New("no Cargo.toml at the root of the project")
 )
 
 type Cargo struct {
-	Ctr  *Container
-	Proj *Directory
+	Ctr     *dagger.Container
+	Proj    *dagger.Directory
+	Version string
+
+	CacheNamespace string
+	DisableCache   bool
+	RegistryCache  *dagger.CacheVolume
+	GitCache       *dagger.CacheVolume
+	TargetCache    *dagger.CacheVolume
+
+	Tools []*InstalledTool
+
+	Vendored     *dagger.Directory
+	VendorConfig string
+
+	Sccache          bool
+	SccacheCache     *dagger.CacheVolume
+	SccacheBucket    string
+	SccacheEndpoint  string
+	SccacheService   *dagger.Service
+	SccacheAccessKey *dagger.Secret
+	SccacheSecretKey *dagger.Secret
 }
 
-// Build the project
-func (c *Cargo) Build(args []string) *Directory {
-	command := append([]string{"cargo", "build"}, args...)
-	return c.prepare().
-		WithExec(command).
-		Directory(PROJ_MOUNT)
+// Work on a Rust project. The default is the root of the repository holding
+// this module, so it only applies when the module lives in the Rust repository
+func New(
+	// Directory with the Cargo.toml of the project or workspace
+	// +defaultPath="/"
+	// +ignore=["**/target"]
+	project *dagger.Directory,
+) *Cargo {
+	return &Cargo{Proj: project}
+}
+
+// Build the project and return it with a target/ holding only what this build
+// produced for the project's own packages, not the rest of the target cache
+func (c *Cargo) Build(ctx context.Context, args []string) (*dagger.Directory, error) {
+	ctr, err := c.prepareOffline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	command := append(append([]string{"cargo", "build"}, c.offlineArgs()...), args...)
+	ctr = stageArtifacts(ctr, command)
+	return ctr.
+		Directory(PROJ_MOUNT).
+		WithoutDirectory("target").
+		WithDirectory("target", ctr.Directory(ARTIFACTS_STAGE+PROJ_TARGET)), nil
 }
 
 // Format the project
 func (c *Cargo) Fmt(ctx context.Context, args []string) (string, error) {
+	ctr, err := c.prepareProject(ctx)
+	if err != nil {
+		return "", err
+	}
 	command := append([]string{"cargo", "fmt"}, args...)
-	return c.prepare().WithExec(command).Stdout(ctx)
+	return ctr.WithExec(command).Stdout(ctx)
 }
 
 // Test the project
 func (c *Cargo) Test(ctx context.Context, args []string) (string, error) {
-	command := append([]string{"cargo", "test"}, args...)
-	return c.prepare().WithExec(command).Stdout(ctx)
-}
-
-// Lint the project
-func (c *Cargo) Clippy(ctx context.Context) (string, error) {
-	return c.prepare().
-		WithExec([]string{"cargo", "clippy"}).
-		Stdout(ctx)
+	ctr, err := c.prepareOffline(ctx)
+	if err != nil {
+		return "", err
+	}
+	command := append(append([]string{"cargo", "test"}, c.offlineArgs()...), args...)
+	return ctr.WithExec(command).Stdout(ctx)
 }
 
-// Sets up the Container with a rust image
+// Sets up the Container with a rust image. The version is an image tag like
+// 1.90, or a rustup channel like beta, nightly or nightly-2024-01-01 that is
+// installed on top of the default image. Channels without a date are installed
+// again each day, rather than cached with the release of the first install
 func (c *Cargo) Base(version string) *Cargo {
-	image := fmt.Sprintf("rust:%s", version)
-	c.Ctr = dag.Container().From(image)
-	return c
-}
-
-// Install a package from a git repository
-func (c *Cargo) InstallFromGit(url string, branch string, bin string, pkg string) *Cargo {
-	command := []string{"cargo", "install", "--git", url, "--branch", branch, "--bin", bin, pkg}
-	c.Ctr = c.prepare().
-		WithMountedCache("/usr/local/cargo/registry", dag.CacheVolume("cargoregistry")).
-		WithExec(command)
-	return c
+	cc := *c
+	if isChannel(version) {
+		ctr := dag.Container().From(fmt.Sprintf("rust:%s", DEFAULT_RUST))
+		if !strings.Contains(version, "-") {
+			ctr = ctr.WithEnvVariable("RUSTUP_CHANNEL_DATE", time.Now().UTC().Format(time.DateOnly))
+		}
+		cc.Ctr = ctr.
+			WithExec([]string{"rustup", "toolchain", "install", version}).
+			WithExec([]string{"rustup", "default", version}).
+			WithEnvVariable("RUST_VERSION", version)
+	} else {
+		image := fmt.Sprintf("rust:%s", version)
+		cc.Ctr = dag.Container().From(image)
+	}
+	cc.Version = version
+	return &cc
 }
 
-// Accessor for the Container
-func (c *Cargo) Container() *Container {
-	return c.Ctr
+// Accessor for the Container with the installed tools, without the project
+func (c *Cargo) Container() *dagger.Container {
+	return c.withTools(c.base())
 }
 
 // Accessor for the Project
-func (c *Cargo) Project() *Directory {
-	return c.Ctr.Directory(PROJ_MOUNT)
+func (c *Cargo) Project() *dagger.Directory {
+	return c.Proj
 }
 
 // Specify the Project to use in the module
-func (c *Cargo) WithProject(dir *Directory) *Cargo {
-	c.Proj = dir
-	return c
+func (c *Cargo) WithProject(dir *dagger.Directory) *Cargo {
+	cc := *c
+	cc.Proj = dir
+	return &cc
 }
 
 // Bring your own container
-func (c *Cargo) WithContainer(ctr *Container) *Cargo {
-	c.Ctr = ctr
-	return c
+func (c *Cargo) WithContainer(ctr *dagger.Container) *Cargo {
+	cc := *c
+	cc.Ctr = ctr
+	cc.Version = "custom"
+	return &cc
 }
 
-// Private func to check readiness and prepare the container for build/test/lint
-func (c *Cargo) prepare() *Container {
+// Check that a project with a Cargo.toml is configured
+func (c *Cargo) validate(ctx context.Context) error {
 	if c.Proj == nil {
-		c.Proj = dag.Directory() // Unsure about this. Maybe want to error
+		return ErrNoProject
 	}
+	entries, err := c.Proj.Entries(ctx)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(entries, "Cargo.toml") {
+		return ErrNoManifest
+	}
+	return nil
+}
+
+// Private func to check readiness and prepare the container for build/test/lint
+func (c *Cargo) prepareProject(ctx context.Context, tools ...tool) (*dagger.Container, error) {
+	if err := c.validate(ctx); err != nil {
+		return nil, err
+	}
+	return c.prepare(ctx, tools...)
+}
 
-	if c.Ctr == nil {
-		cd := c.Base(DEFAULT_RUST)
-		c.Ctr = cd.Ctr
+// Private func to derive a container for a command, with the project mounted if any.
+// The receiver is never modified, so commands do not depend on call order.
+// Tools are installed before the project is mounted, so source changes do not reinstall them
+func (c *Cargo) prepare(ctx context.Context, tools ...tool) (*dagger.Container, error) {
+	ctr := c.toolchain()
+	if c.Sccache {
+		ctr = cargoTool("sccache@" + SCCACHE_VERSION)(ctr)
 	}
+	for _, install := range tools {
+		ctr = install(ctr)
+	}
+	project := ""
+	if c.Proj != nil {
+		ctr = ctr.WithDirectory(PROJ_MOUNT, c.Proj)
+		if !c.DisableCache && c.TargetCache == nil {
+			name, err := c.projectName(ctx)
+			if err != nil {
+				return nil, err
+			}
+			project = name
+		}
+	}
+	return c.withSccache(c.withCaches(ctr.WithWorkdir(PROJ_MOUNT), project)), nil
+}
 
-	c.Ctr = c.Ctr.
-		WithDirectory(PROJ_MOUNT, c.Proj).
-		WithWorkdir(PROJ_MOUNT)
-	return c.Ctr
+// The base container with the installed tools and the toolchain pinned by the
+// project, if any. Without an explicit base, only the toolchain files are
+// mounted to install it, so that source changes do not download it again
+func (c *Cargo) toolchain() *dagger.Container {
+	ctr := c.withTools(c.base())
+	if c.Ctr != nil || c.Proj == nil {
+		return ctr
+	}
+	files := dag.Directory().WithDirectory(".", c.Proj, dagger.DirectoryWithDirectoryOpts{Include: TOOLCHAIN_FILES})
+	return ctr.
+		WithDirectory(PROJ_MOUNT, files).
+		WithWorkdir(PROJ_MOUNT).
+		WithExec([]string{"sh", "-c", INSTALL_PINNED_TOOLCHAIN})
+}
+
+// The container set up by Base or WithContainer, or the default rust image
+func (c *Cargo) base() *dagger.Container {
+	if c.Ctr != nil {
+		return c.Ctr
+	}
+	return c.Base(DEFAULT_RUST).Ctr
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestBaseIsImmutable(t *testing.T) {
+	c := New(nil)
+	before := *c
+
+	first := c.Base("nightly")
+	second := c.Base("nightly")
+	if !reflect.DeepEqual(*c, before) {
+		t.Fatalf("Base modified the receiver: %+v", *c)
+	}
+	if first.Version != "nightly" || second.Version != first.Version {
+		t.Fatalf("versions %q and %q, want nightly", first.Version, second.Version)
+	}
+	if first.Ctr == nil || first.Ctr == c.Ctr {
+		t.Fatalf("Base did not derive a container")
+	}
+	if got, want := first.cacheKey("target", "app"), second.cacheKey("target", "app"); got != want {
+		t.Fatalf("cache keys %q and %q differ", got, want)
+	}
+	if c.Base("1.75").Version != "1.75" || c.Version != "" {
+		t.Fatalf("Base leaked its version into the receiver")
+	}
+}
+
+func TestBuildersCommute(t *testing.T) {
+	c := New(nil)
+	before := *c
+	dir := dag.Directory()
+
+	a := c.WithProject(dir).WithCacheNamespace("repo").Base("beta")
+	b := c.Base("beta").WithCacheNamespace("repo").WithProject(dir)
+	if !reflect.DeepEqual(*c, before) {
+		t.Fatalf("builders modified the receiver: %+v", *c)
+	}
+	if a.Proj != dir || b.Proj != dir {
+		t.Fatalf("project not set on both")
+	}
+	if a.Version != b.Version || a.CacheNamespace != b.CacheNamespace {
+		t.Fatalf("got %q/%q and %q/%q", a.Version, a.CacheNamespace, b.Version, b.CacheNamespace)
+	}
+	for _, kind := range []string{"registry", "git", "target"} {
+		if got, want := a.cacheKey(kind, "app"), b.cacheKey(kind, "app"); got != want {
+			t.Fatalf("%s cache keys %q and %q differ", kind, got, want)
+		}
+	}
+	if got := a.cacheKey("target", "app"); got != "cargo-target-beta-app-repo" {
+		t.Fatalf("cache key %q", got)
+	}
+	if got := c.cacheKey("registry", ""); got != "cargo-registry-"+DEFAULT_RUST {
+		t.Fatalf("cache key %q", got)
+	}
+}
+
+func install(t *testing.T, c *Cargo, crate string) *Cargo {
+	t.Helper()
+	cc, err := c.Install(crate, "", "", "", "", "", nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+func TestInstallIsImmutable(t *testing.T) {
+	c := install(t, New(nil).WithCacheNamespace("tools"), "ripgrep")
+	before := *c
+	before.Tools = slices.Clone(c.Tools)
+
+	first, second := install(t, c, "fd-find"), install(t, c, "bat")
+	if !reflect.DeepEqual(*c, before) {
+		t.Fatalf("Install modified the receiver: %+v", *c)
+	}
+	if first.Ctr != nil {
+		t.Fatalf("Install replaced the container")
+	}
+	if len(first.Tools) != 2 || first.Tools[1].Command[3] != "fd-find" || second.Tools[1].Command[3] != "bat" {
+		t.Fatalf("installs of one receiver share their tools: %v and %v", first.Tools, second.Tools)
+	}
+	if first.CacheNamespace != "tools" || first.Version != c.Version {
+		t.Fatalf("Install changed the configuration: %+v", *first)
+	}
+	if _, err := c.Install("", "", "", "", "", "", nil, nil, false); err == nil {
+		t.Fatalf("Install without a source did not fail")
+	}
+	if !reflect.DeepEqual(*c, before) {
+		t.Fatalf("failed Install modified the receiver")
+	}
+}
+
+func TestInstallCommutesWithBase(t *testing.T) {
+	c := New(nil)
+
+	a := install(t, c, "ripgrep").Base("nightly").WithoutCache()
+	b := install(t, c.WithoutCache().Base("nightly"), "ripgrep")
+	if !reflect.DeepEqual(a.Tools, b.Tools) || len(a.Tools) != 1 {
+		t.Fatalf("tools %v and %v", a.Tools, b.Tools)
+	}
+	if a.Version != b.Version || a.DisableCache != b.DisableCache {
+		t.Fatalf("got %q/%v and %q/%v", a.Version, a.DisableCache, b.Version, b.DisableCache)
+	}
+	if custom := install(t, c, "ripgrep").WithContainer(dag.Container()); len(custom.Tools) != 1 {
+		t.Fatalf("WithContainer dropped the installed tools")
+	}
+	if len(c.Tools) != 0 {
+		t.Fatalf("builders modified the receiver")
+	}
+}
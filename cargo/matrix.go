@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Outcome of the tests for one Rust version and feature set
+type MatrixResult struct {
+	Version  string
+	Features string
+	Success  bool
+	ExitCode int
+	// Wall time of cargo test, in milliseconds
+	Duration int
+	// Last lines of the test output
+	LogExcerpt string
+}
+
+// Run the tests for every combination of Rust version and feature set in parallel.
+// Versions are image tags or rustup channels (stable, beta, nightly).
+// A feature set is a comma separated list of features, or one of default, all and none
+func (c *Cargo) TestMatrix(
+	ctx context.Context,
+	versions []string,
+	// +optional
+	featureSets []string,
+	// +optional
+	args []string,
+) ([]*MatrixResult, error) {
+	if err := c.validate(ctx); err != nil {
+		return nil, err
+	}
+	if len(featureSets) == 0 {
+		featureSets = []string{"default"}
+	}
+
+	results := make([]*MatrixResult, len(versions)*len(featureSets))
+	eg, gctx := errgroup.WithContext(ctx)
+	for i, version := range versions {
+		ctr, err := c.versionContainer(ctx, version)
+		if err != nil {
+			return nil, err
+		}
+		for j, features := range featureSets {
+			idx, version, features := i*len(featureSets)+j, version, features
+			eg.Go(func() error {
+				command := append([]string{"cargo", "test"}, featureArgs(features)...)
+				command = append(command, args...)
+				run := execAllowFailure(ctr, timed(pinToolchain(command)))
+				output, exit, err := execResult(gctx, run)
+				if err != nil {
+					return err
+				}
+				duration, err := run.File(DURATION).Contents(gctx)
+				if err != nil {
+					return err
+				}
+				ms, _ := strconv.Atoi(strings.TrimSpace(duration))
+				results[idx] = &MatrixResult{
+					Version:    version,
+					Features:   features,
+					Success:    exit == 0,
+					ExitCode:   exit,
+					Duration:   ms,
+					LogExcerpt: tail(output, 20),
+				}
+				return nil
+			})
+		}
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Cargo flags selecting a feature set
+func featureArgs(features string) []string {
+	switch features {
+	case "", "default":
+		return nil
+	case "all":
+		return []string{"--all-features"}
+	case "none":
+		return []string{"--no-default-features"}
+	default:
+		return []string{"--features", features}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"dagger/cargo/internal/dagger"
+)
+
+// Output of `cargo metadata --format-version 1`
+type cargoMetadata struct {
+	Packages         []cargoPackage `json:"packages"`
+	WorkspaceMembers []string       `json:"workspace_members"`
+	WorkspaceRoot    string         `json:"workspace_root"`
+	// Only without --no-deps
+	Resolve *struct {
+		Nodes []struct {
+			ID       string   `json:"id"`
+			Features []string `json:"features"`
+		} `json:"nodes"`
+	} `json:"resolve"`
+}
+
+type cargoPackage struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	ManifestPath string            `json:"manifest_path"`
+	Targets      []cargoTarget     `json:"targets"`
+	Dependencies []cargoDependency `json:"dependencies"`
+	Description  string            `json:"description"`
+	License      string            `json:"license"`
+	Repository   string            `json:"repository"`
+	Homepage     string            `json:"homepage"`
+	Authors      []string          `json:"authors"`
+	// Empty for path dependencies and workspace members
+	Source string `json:"source"`
+}
+
+// The parts of Cargo.toml read without running cargo. In a package,
+// rust-version is a string, or a table with workspace = true to inherit
+// [workspace.package]
+type cargoManifest struct {
+	Package *struct {
+		Name        string `toml:"name"`
+		RustVersion any    `toml:"rust-version"`
+	} `toml:"package"`
+	Workspace struct {
+		Members []string `toml:"members"`
+		Package struct {
+			RustVersion string `toml:"rust-version"`
+		} `toml:"package"`
+	} `toml:"workspace"`
+}
+
+type cargoTarget struct {
+	Name string   `json:"name"`
+	Kind []string `json:"kind"`
+	// Features to enable for the target to be built
+	RequiredFeatures []string `json:"required-features"`
+}
+
+type cargoDependency struct {
+	Name string `json:"name"`
+	Req  string `json:"req"`
+	Kind string `json:"kind"`
+}
+
+// Names of the binary targets of the package
+func (p cargoPackage) bins() []string {
+	var bins []string
+	for _, t := range p.binTargets() {
+		bins = append(bins, t.Name)
+	}
+	return bins
+}
+
+// Whether the package has a library target, which doc tests are run for
+func (p cargoPackage) hasLib() bool {
+	return slices.ContainsFunc(p.Targets, func(t cargoTarget) bool {
+		return slices.ContainsFunc(t.Kind, func(kind string) bool {
+			return kind == "lib" || kind == "rlib" || kind == "dylib" || kind == "proc-macro"
+		})
+	})
+}
+
+// Binary targets of the package
+func (p cargoPackage) binTargets() []cargoTarget {
+	var bins []cargoTarget
+	for _, t := range p.Targets {
+		if slices.Contains(t.Kind, "bin") {
+			bins = append(bins, t)
+		}
+	}
+	return bins
+}
+
+// Read the workspace metadata, without resolving dependencies
+func metadata(ctx context.Context, ctr *dagger.Container) (*cargoMetadata, error) {
+	out, err := ctr.
+		WithExec([]string{"cargo", "metadata", "--format-version", "1", "--no-deps"}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var meta cargoMetadata
+	if err := json.Unmarshal([]byte(out), &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// Target triple of the toolchain in the container
+func hostTriple(ctx context.Context, ctr *dagger.Container) (string, error) {
+	out, err := ctr.WithExec([]string{"rustc", "-vV"}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if host, ok := strings.CutPrefix(line, "host: "); ok {
+			return strings.TrimSpace(host), nil
+		}
+	}
+	return "", fmt.Errorf("no host triple in rustc -vV output:\n%s", out)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dagger/cargo/internal/dagger"
+)
+
+// Alias of the registry service bound with Publish
+const REGISTRY_HOST = "registry"
+
+// A packaged crate, with the files it includes for review
+type CratePackage struct {
+	Crate *dagger.File
+	Files []string
+}
+
+// Package the crate as a .crate file. Select the package with pkg in a workspace
+func (c *Cargo) Package(
+	ctx context.Context,
+	// +optional
+	pkg string,
+	// +optional
+	args []string,
+) (*CratePackage, error) {
+	ctr, err := c.prepareProject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := metadata(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+	if pkg == "" && len(meta.Packages) != 1 {
+		return nil, fmt.Errorf("workspace has %d packages, pick one with pkg", len(meta.Packages))
+	}
+
+	var selected *cargoPackage
+	for i := range meta.Packages {
+		if pkg == "" || meta.Packages[i].Name == pkg {
+			selected = &meta.Packages[i]
+			break
+		}
+	}
+	if selected == nil {
+		return nil, fmt.Errorf("package %q not found in the workspace", pkg)
+	}
+
+	command := append([]string{"cargo", "package", "-p", selected.Name}, args...)
+	list, err := ctr.
+		WithExec(append(command, "--list")).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copied in the same exec as cargo package, since the target cache is
+	// shared with other builds
+	crate := fmt.Sprintf("%s-%s.crate", selected.Name, selected.Version)
+	script := fmt.Sprintf(`"$@" && mkdir -p %[1]s && cp target/package/%[2]s %[1]s/`, OUT_MOUNT, crate)
+	file := ctr.
+		WithExec(append([]string{"sh", "-c", script, "sh"}, command...)).
+		File(OUT_MOUNT + "/" + crate)
+	return &CratePackage{Crate: file, Files: strings.Split(strings.TrimSpace(list), "\n")}, nil
+}
+
+// Publish the crate to crates.io, to a registry named in .cargo/config.toml,
+// or to the index at registryUrl. A registry service, e.g. a local Kellnr,
+// can be bound as host "registry". The token is not needed for a dry run
+func (c *Cargo) Publish(
+	ctx context.Context,
+	// +optional
+	token *dagger.Secret,
+	// Name of a registry configured in .cargo/config.toml
+	// +optional
+	registry string,
+	// Index URL of an alternative registry, e.g. sparse+http://registry:8000/api/v1/crates/
+	// +optional
+	registryUrl string,
+	// +optional
+	registryService *dagger.Service,
+	// +optional
+	dryRun bool,
+	// +optional
+	pkg string,
+	// +optional
+	args []string,
+) (string, error) {
+	if registry != "" && registryUrl != "" {
+		return "", fmt.Errorf("only one of registry and registryUrl can be set")
+	}
+	if token == nil && !dryRun {
+		return "", fmt.Errorf("a token is required unless publishing as a dry run")
+	}
+
+	ctr, err := c.prepareProject(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	command := []string{"cargo", "publish"}
+	if registry != "" {
+		command = append(command, "--registry", registry)
+	}
+	if registryUrl != "" {
+		command = append(command, "--index", registryUrl)
+	}
+	if dryRun {
+		command = append(command, "--dry-run")
+	}
+	if pkg != "" {
+		command = append(command, "-p", pkg)
+	}
+	command = append(command, args...)
+
+	if registryService != nil {
+		ctr = ctr.WithServiceBinding(REGISTRY_HOST, registryService)
+	}
+	if token != nil {
+		// Passed through the environment so the token never shows up in the pipeline
+		ctr = ctr.WithSecretVariable("CARGO_PUBLISH_TOKEN", token)
+		command = append([]string{"sh", "-c", `exec "$@" --token "$CARGO_PUBLISH_TOKEN"`, "sh"}, command...)
+	}
+	return ctr.WithExec(command).Stderr(ctx)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dagger/cargo/internal/dagger"
+)
+
+// Build stripped release binaries and package them as <name>-<version>-<triple>
+// archives, each with a .sha256 checksum file. Defaults to the host triple.
+// Every binary of the workspace is built on its own, with its required features
+func (c *Cargo) Release(
+	ctx context.Context,
+	// +optional
+	targets []string,
+	// +optional
+	// +default="tar.gz"
+	format string,
+	// +optional
+	args []string,
+) (*dagger.Directory, error) {
+	if format == "" {
+		format = "tar.gz"
+	}
+	if format != "tar.gz" && format != "zip" {
+		return nil, fmt.Errorf("unsupported archive format %q, expected tar.gz or zip", format)
+	}
+
+	if err := c.validate(ctx); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		host, err := hostTriple(ctx, c.base())
+		if err != nil {
+			return nil, err
+		}
+		targets = []string{host}
+	}
+	tools := crossTools(targets...)
+	if format == "zip" {
+		tools = append(tools, aptPackages("zip"))
+	}
+	ctr, err := c.prepareProject(ctx, tools...)
+	if err != nil {
+		return nil, err
+	}
+	ctr = ctr.WithEnvVariable("CARGO_PROFILE_RELEASE_STRIP", "symbols")
+
+	meta, err := metadata(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+
+	out := dag.Directory()
+	for _, target := range targets {
+		cross := withCrossTarget(ctr, target)
+		for _, pkg := range meta.Packages {
+			for _, bin := range pkg.binTargets() {
+				command := []string{"cargo", "build", "--release", "--target", target, "-p", pkg.Name, "--bin", bin.Name}
+				if len(bin.RequiredFeatures) > 0 {
+					command = append(command, "--features", strings.Join(bin.RequiredFeatures, ","))
+				}
+				name := fmt.Sprintf("%s-%s-%s", bin.Name, pkg.Version, target)
+				archive := releaseArchive(cross, append(command, args...), fmt.Sprintf("target/%s/release/%s", target, bin.Name), name, format)
+				out = out.WithDirectory(".", archive)
+			}
+		}
+	}
+	return out, nil
+}
+
+// Build a binary and package it in an archive named after the release, with its
+// checksum. The binary is copied in the same exec as the build, since the
+// target cache is shared with other builds that could replace it
+func releaseArchive(ctr *dagger.Container, command []string, bin, name, format string) *dagger.Directory {
+	archive := name + "." + format
+	pack := fmt.Sprintf("tar czf %s %s", archive, name)
+	if format == "zip" {
+		pack = fmt.Sprintf("zip -r %s %s", archive, name)
+	}
+	script := fmt.Sprintf(
+		`"$@" && mkdir -p %[1]s/%[2]s && cp %[3]s %[1]s/%[2]s/ && cd %[1]s && %[4]s && sha256sum %[5]s > %[5]s.sha256 && rm -rf %[2]s`,
+		OUT_MOUNT, name, bin, pack, archive,
+	)
+	return ctr.
+		WithExec(append([]string{"sh", "-c", script, "sh"}, command...)).
+		Directory(OUT_MOUNT)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"dagger/cargo/internal/dagger"
+)
+
+const (
+	PROVENANCE_BUILDER = "https://github.com/grouville/daggerverse/cargo"
+	PROVENANCE_TYPE    = PROVENANCE_BUILDER + "/reproducible-build@v1"
+	// Paths remapped so that no build location leaks into the binaries
+	REMAP_FLAGS = "--remap-path-prefix=" + PROJ_MOUNT + "=. --remap-path-prefix=/usr/local/cargo=/cargo --remap-path-prefix=/usr/local/rustup=/rustup"
+)
+
+// Release binaries proven reproducible, with their provenance
+type ReproducibleBuild struct {
+	Binaries *dagger.Directory
+	// in-toto statement with a SLSA v1 provenance predicate
+	Provenance *dagger.File
+}
+
+// Build the release binaries twice in independent containers, with
+// SOURCE_DATE_EPOCH, paths remapped on top of the project's rustflags and
+// locked dependencies, and fail unless both outputs are byte-for-byte identical
+func (c *Cargo) ReproducibleBuild(
+	ctx context.Context,
+	// +optional
+	sourceDateEpoch int,
+	// +optional
+	args []string,
+) (*ReproducibleBuild, error) {
+	if err := c.validate(ctx); err != nil {
+		return nil, err
+	}
+	entries, err := c.Proj.Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(entries, "Cargo.lock") {
+		return nil, fmt.Errorf("a Cargo.lock is required to build with locked dependencies")
+	}
+	lock, err := c.Proj.File("Cargo.lock").Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lockSum := sha256.Sum256([]byte(lock))
+
+	// Cold builds that share nothing, not even the sccache cache, the build
+	// number keeps them from being deduplicated
+	cold := c.WithoutCache()
+	cold.Sccache = false
+	command := append([]string{"cargo", "build", "--release", "--locked"}, args...)
+	base, err := cold.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rustflags, err := c.rustflags(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	rustflags = strings.TrimSpace(rustflags + " " + REMAP_FLAGS)
+	build := func(n int) *dagger.Container {
+		return base.
+			WithEnvVariable("SOURCE_DATE_EPOCH", strconv.Itoa(sourceDateEpoch)).
+			WithEnvVariable("RUSTFLAGS", rustflags).
+			WithEnvVariable("REPRODUCIBLE_BUILD", strconv.Itoa(n)).
+			WithExec(command).
+			WithExec([]string{"sh", "-c", fmt.Sprintf(
+				"mkdir -p %[1]s && find target/release -maxdepth 1 -type f -executable -exec cp {} %[1]s \\; && cd %[1]s && if [ -n \"$(ls)\" ]; then sha256sum *; fi > /tmp/sha256sums",
+				OUT_MOUNT,
+			)})
+	}
+	first, second := build(1), build(2)
+
+	sums := make([]map[string]string, 2)
+	for i, ctr := range []*dagger.Container{first, second} {
+		out, err := ctr.File("/tmp/sha256sums").Contents(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sums[i] = parseSha256sums(out)
+	}
+	if len(sums[0]) == 0 {
+		return nil, fmt.Errorf("the release build produced no executables in target/release")
+	}
+
+	var mismatches []string
+	for name, sum := range sums[0] {
+		if sums[1][name] != sum {
+			mismatches = append(mismatches, fmt.Sprintf("%s: %s != %s", name, sum, sums[1][name]))
+		}
+	}
+	if len(sums[0]) != len(sums[1]) {
+		mismatches = append(mismatches, fmt.Sprintf("built %d binaries then %d", len(sums[0]), len(sums[1])))
+	}
+	if len(mismatches) > 0 {
+		slices.Sort(mismatches)
+		return nil, fmt.Errorf("build is not reproducible:\n%s", strings.Join(mismatches, "\n"))
+	}
+
+	rustc, err := first.WithExec([]string{"rustc", "-V"}).Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	provenance, err := slsaProvenance(sums[0], hex.EncodeToString(lockSum[:]), strings.TrimSpace(rustc), rustflags, sourceDateEpoch, command)
+	if err != nil {
+		return nil, err
+	}
+	return &ReproducibleBuild{
+		Binaries: first.Directory(OUT_MOUNT),
+		Provenance: dag.Directory().
+			WithNewFile("provenance.json", provenance).
+			File("provenance.json"),
+	}, nil
+}
+
+// The flags cargo would pass to rustc without the module, which setting RUSTFLAGS
+// overrides: RUSTFLAGS from the container, else target.<host>.rustflags or
+// build.rustflags from the project's .cargo/config.toml
+func (c *Cargo) rustflags(ctx context.Context, ctr *dagger.Container) (string, error) {
+	env, err := ctr.EnvVariable(ctx, "RUSTFLAGS")
+	if err != nil || env != "" {
+		return env, err
+	}
+	// Cargo reads the file without extension when both exist
+	for _, name := range []string{".cargo/config", ".cargo/config.toml"} {
+		found, err := c.Proj.Glob(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		if len(found) == 0 {
+			continue
+		}
+		content, err := c.Proj.File(name).Contents(ctx)
+		if err != nil {
+			return "", err
+		}
+		host, err := hostTriple(ctx, ctr)
+		if err != nil {
+			return "", err
+		}
+		return configRustflags(content, host)
+	}
+	return "", nil
+}
+
+// The rustflags of a cargo config for the host, which take precedence over
+// build.rustflags. Flags are a space separated string or an array
+func configRustflags(content, host string) (string, error) {
+	type flags struct {
+		Rustflags any `toml:"rustflags"`
+	}
+	var config struct {
+		Build  flags            `toml:"build"`
+		Target map[string]flags `toml:"target"`
+	}
+	if _, err := toml.Decode(content, &config); err != nil {
+		return "", fmt.Errorf(".cargo/config.toml: %w", err)
+	}
+	for _, value := range []any{config.Target[host].Rustflags, config.Build.Rustflags} {
+		switch v := value.(type) {
+		case string:
+			if v != "" {
+				return v, nil
+			}
+		case []any:
+			if len(v) > 0 {
+				parts := make([]string, len(v))
+				for i, part := range v {
+					parts[i] = fmt.Sprint(part)
+				}
+				return strings.Join(parts, " "), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// Map of file name to digest from sha256sum output
+func parseSha256sums(out string) map[string]string {
+	sums := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if sum, name, ok := strings.Cut(line, "  "); ok {
+			sums[name] = sum
+		}
+	}
+	return sums
+}
+
+// in-toto statement describing the binaries and the inputs they were built from
+func slsaProvenance(sums map[string]string, lockSum, rustc, rustflags string, epoch int, command []string) (string, error) {
+	type digest map[string]string
+	type resource struct {
+		Name   string `json:"name"`
+		Digest digest `json:"digest"`
+	}
+
+	var subjects []resource
+	for name, sum := range sums {
+		subjects = append(subjects, resource{Name: name, Digest: digest{"sha256": sum}})
+	}
+	slices.SortFunc(subjects, func(a, b resource) int { return strings.Compare(a.Name, b.Name) })
+
+	statement := map[string]any{
+		"_type":         "https://in-toto.io/Statement/v1",
+		"subject":       subjects,
+		"predicateType": "https://slsa.dev/provenance/v1",
+		"predicate": map[string]any{
+			"buildDefinition": map[string]any{
+				"buildType": PROVENANCE_TYPE,
+				"externalParameters": map[string]any{
+					"command": command,
+				},
+				"internalParameters": map[string]any{
+					"rustc":           rustc,
+					"sourceDateEpoch": epoch,
+					"rustflags":       rustflags,
+				},
+				"resolvedDependencies": []resource{
+					{Name: "Cargo.lock", Digest: digest{"sha256": lockSum}},
+				},
+			},
+			"runDetails": map[string]any{
+				"builder": map[string]any{"id": PROVENANCE_BUILDER},
+			},
+		},
+	}
+	out, err := json.MarshalIndent(statement, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
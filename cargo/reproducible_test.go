@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestConfigRustflags(t *testing.T) {
+	host := "x86_64-unknown-linux-gnu"
+	tests := map[string]string{
+		"[build]\nrustflags = [\"-C\", \"target-cpu=native\"]\n":                                                      "-C target-cpu=native",
+		"[build]\nrustflags = \"--cfg tokio_unstable\"\n":                                                             "--cfg tokio_unstable",
+		"[build]\nrustflags = [\"-Dwarnings\"]\n[target.x86_64-unknown-linux-gnu]\nrustflags = [\"-Clink-arg=-s\"]\n": "-Clink-arg=-s",
+		"[target.aarch64-unknown-linux-gnu]\nrustflags = [\"-Clink-arg=-s\"]\n":                                       "",
+		"[net]\noffline = true\n": "",
+	}
+	for config, want := range tests {
+		got, err := configRustflags(config, host)
+		if err != nil || got != want {
+			t.Errorf("%q: got %q, %v, want %q", config, got, err, want)
+		}
+	}
+}
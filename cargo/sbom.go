@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"dagger/cargo/internal/dagger"
+)
+
+const CRATES_IO = "registry+https://github.com/rust-lang/crates.io-index"
+
+var spdxIDUnsafe = regexp.MustCompile(`[^a-zA-Z0-9.-]`)
+
+// Software bill of materials of the project
+type Sbom struct {
+	// CycloneDX 1.5 JSON document
+	CycloneDx *dagger.File
+	// SPDX 2.3 JSON document
+	Spdx *dagger.File
+}
+
+// A [[package]] entry of Cargo.lock
+type lockedPackage struct {
+	Name         string
+	Version      string
+	Source       string
+	Checksum     string
+	Dependencies []string
+	// From cargo metadata, when the sources are available offline
+	License  string
+	Features []string
+}
+
+// Package URL, qualified with the repository for sources other than crates.io.
+// The project's own crates have none
+func (p *lockedPackage) purl() string {
+	purl := fmt.Sprintf("pkg:cargo/%s@%s", p.Name, p.Version)
+	kind, location, _ := strings.Cut(p.Source, "+")
+	switch {
+	case p.Source == "":
+		return ""
+	case p.Source == CRATES_IO:
+		return purl
+	case kind == "git":
+		// git+<url>?branch=main#<rev> is pinned as git+<url>@<rev>
+		repo, rev, _ := strings.Cut(location, "#")
+		repo, _, _ = strings.Cut(repo, "?")
+		return purl + "?vcs_url=" + url.QueryEscape("git+"+repo+"@"+rev)
+	default:
+		return purl + "?repository_url=" + url.QueryEscape(location)
+	}
+}
+
+// Reference of the package in the CycloneDX document
+func (p *lockedPackage) bomRef() string {
+	if purl := p.purl(); purl != "" {
+		return purl
+	}
+	return p.Name + "@" + p.Version
+}
+
+// SPDX identifier, with a digest of the source when the same name and version
+// can be locked from several sources
+func (p *lockedPackage) spdxID() string {
+	id := p.Name + "-" + p.Version
+	if p.Source != "" && p.Source != CRATES_IO {
+		sum := sha256.Sum256([]byte(p.Source))
+		id += "-" + hex.EncodeToString(sum[:4])
+	}
+	return "SPDXRef-Package-" + spdxIDUnsafe.ReplaceAllString(id, "-")
+}
+
+// Generate CycloneDX and SPDX documents from Cargo.lock, without network
+// access. Licenses and enabled features come from cargo metadata when the
+// dependency sources are vendored or already in the registry cache
+func (c *Cargo) Sbom(ctx context.Context) (*Sbom, error) {
+	ctr, err := c.prepareOffline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := c.Proj.Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(entries, "Cargo.lock") {
+		return nil, fmt.Errorf("a Cargo.lock is required to generate an SBOM")
+	}
+	lock, err := c.Proj.File("Cargo.lock").Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	packages := parseCargoLock(lock)
+
+	// Best effort: without the sources offline, the SBOM only has what Cargo.lock records
+	meta := ctr.WithEnvVariable("CARGO_NET_OFFLINE", "true")
+	out, exit, err := execResult(ctx, execAllowFailureStdout(meta, []string{"cargo", "metadata", "--format-version", "1", "--offline", "--locked"}))
+	if err != nil {
+		return nil, err
+	}
+	var full cargoMetadata
+	if exit == 0 && json.Unmarshal([]byte(out), &full) == nil {
+		enrichFromMetadata(packages, &full)
+	}
+
+	// Name-based UUID derived from Cargo.lock, with the version and variant bits
+	// of a UUIDv5 so that validators accept it
+	sum := sha256.Sum256([]byte(lock))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	id := fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+
+	cyclonedx, err := cycloneDxDocument(packages, id)
+	if err != nil {
+		return nil, err
+	}
+	spdx, err := spdxDocument(packages, id)
+	if err != nil {
+		return nil, err
+	}
+	return &Sbom{
+		CycloneDx: dag.Directory().WithNewFile("sbom.cdx.json", cyclonedx).File("sbom.cdx.json"),
+		Spdx:      dag.Directory().WithNewFile("sbom.spdx.json", spdx).File("sbom.spdx.json"),
+	}, nil
+}
+
+// Parse the [[package]] entries of a Cargo.lock
+func parseCargoLock(lock string) []*lockedPackage {
+	var packages []*lockedPackage
+	var current *lockedPackage
+	inDeps := false
+
+	scanner := bufio.NewScanner(strings.NewReader(lock))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "[[package]]":
+			current = &lockedPackage{}
+			packages = append(packages, current)
+			inDeps = false
+		case strings.HasPrefix(line, "["):
+			current = nil
+		case current == nil:
+		case inDeps:
+			if line == "]" {
+				inDeps = false
+			} else {
+				current.Dependencies = append(current.Dependencies, strings.Trim(line, `",`))
+			}
+		case line == "dependencies = [":
+			inDeps = true
+		default:
+			key, value, ok := strings.Cut(line, " = ")
+			if !ok {
+				continue
+			}
+			value = strings.Trim(value, `"`)
+			switch key {
+			case "name":
+				current.Name = value
+			case "version":
+				current.Version = value
+			case "source":
+				current.Source = value
+			case "checksum":
+				current.Checksum = value
+			}
+		}
+	}
+	return packages
+}
+
+// Add licenses and enabled features from the resolved metadata
+func enrichFromMetadata(packages []*lockedPackage, meta *cargoMetadata) {
+	features := map[string][]string{}
+	if meta.Resolve != nil {
+		for _, node := range meta.Resolve.Nodes {
+			features[node.ID] = node.Features
+		}
+	}
+	for _, pkg := range meta.Packages {
+		for _, p := range packages {
+			if p.Name == pkg.Name && p.Version == pkg.Version {
+				p.License = pkg.License
+				p.Features = features[pkg.ID]
+			}
+		}
+	}
+}
+
+// Find the locked package a Cargo.lock dependency entry refers to.
+// Entries are "name", "name version" when several versions are locked, or
+// "name version (source)" when the same version is locked from several sources
+func findLocked(packages []*lockedPackage, dep string) *lockedPackage {
+	fields := strings.SplitN(dep, " ", 3)
+	for _, p := range packages {
+		if p.Name == fields[0] &&
+			(len(fields) < 2 || p.Version == fields[1]) &&
+			(len(fields) < 3 || p.Source == strings.Trim(fields[2], "()")) {
+			return p
+		}
+	}
+	return nil
+}
+
+func cycloneDxDocument(packages []*lockedPackage, id string) (string, error) {
+	type hash struct {
+		Alg     string `json:"alg"`
+		Content string `json:"content"`
+	}
+	type license struct {
+		Expression string `json:"expression"`
+	}
+	type property struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	type component struct {
+		Type       string     `json:"type"`
+		BomRef     string     `json:"bom-ref"`
+		Name       string     `json:"name"`
+		Version    string     `json:"version"`
+		Purl       string     `json:"purl,omitempty"`
+		Licenses   []license  `json:"licenses,omitempty"`
+		Hashes     []hash     `json:"hashes,omitempty"`
+		Properties []property `json:"properties,omitempty"`
+	}
+	type dependency struct {
+		Ref       string   `json:"ref"`
+		DependsOn []string `json:"dependsOn"`
+	}
+
+	components := []component{}
+	dependencies := []dependency{}
+	for _, p := range packages {
+		comp := component{Type: "library", BomRef: p.bomRef(), Name: p.Name, Version: p.Version, Purl: p.purl()}
+		if p.License != "" {
+			comp.Licenses = []license{{Expression: p.License}}
+		}
+		if p.Checksum != "" {
+			comp.Hashes = []hash{{Alg: "SHA-256", Content: p.Checksum}}
+		}
+		if len(p.Features) > 0 {
+			comp.Properties = []property{{Name: "cargo:features", Value: strings.Join(p.Features, ",")}}
+		}
+		components = append(components, comp)
+
+		dep := dependency{Ref: p.bomRef(), DependsOn: []string{}}
+		for _, d := range p.Dependencies {
+			if locked := findLocked(packages, d); locked != nil {
+				dep.DependsOn = append(dep.DependsOn, locked.bomRef())
+			}
+		}
+		dependencies = append(dependencies, dep)
+	}
+
+	doc := map[string]any{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + id,
+		"version":      1,
+		"metadata": map[string]any{
+			"tools": []map[string]string{{"name": "dagger-cargo"}},
+		},
+		"components":   components,
+		"dependencies": dependencies,
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	return string(out), err
+}
+
+func spdxDocument(packages []*lockedPackage, id string) (string, error) {
+	type checksum struct {
+		Algorithm     string `json:"algorithm"`
+		ChecksumValue string `json:"checksumValue"`
+	}
+	type externalRef struct {
+		ReferenceCategory string `json:"referenceCategory"`
+		ReferenceType     string `json:"referenceType"`
+		ReferenceLocator  string `json:"referenceLocator"`
+	}
+	type pkg struct {
+		SPDXID           string        `json:"SPDXID"`
+		Name             string        `json:"name"`
+		VersionInfo      string        `json:"versionInfo"`
+		DownloadLocation string        `json:"downloadLocation"`
+		FilesAnalyzed    bool          `json:"filesAnalyzed"`
+		LicenseConcluded string        `json:"licenseConcluded"`
+		LicenseDeclared  string        `json:"licenseDeclared"`
+		Checksums        []checksum    `json:"checksums,omitempty"`
+		ExternalRefs     []externalRef `json:"externalRefs,omitempty"`
+		Comment          string        `json:"comment,omitempty"`
+	}
+	type relationship struct {
+		SpdxElementID      string `json:"spdxElementId"`
+		RelationshipType   string `json:"relationshipType"`
+		RelatedSpdxElement string `json:"relatedSpdxElement"`
+	}
+
+	pkgs := []pkg{}
+	relationships := []relationship{}
+	name := "cargo-project"
+	for _, p := range packages {
+		entry := pkg{
+			SPDXID:           p.spdxID(),
+			Name:             p.Name,
+			VersionInfo:      p.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+		}
+		if purl := p.purl(); purl != "" {
+			entry.ExternalRefs = []externalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: purl}}
+		}
+		if p.Source == CRATES_IO {
+			entry.DownloadLocation = fmt.Sprintf("https://crates.io/api/v1/crates/%s/%s/download", p.Name, p.Version)
+		}
+		if p.License != "" {
+			entry.LicenseDeclared = p.License
+		}
+		if p.Checksum != "" {
+			entry.Checksums = []checksum{{Algorithm: "SHA256", ChecksumValue: p.Checksum}}
+		}
+		if len(p.Features) > 0 {
+			entry.Comment = "cargo features: " + strings.Join(p.Features, ",")
+		}
+		pkgs = append(pkgs, entry)
+
+		// Packages without a source are the project's own crates
+		if p.Source == "" {
+			if name == "cargo-project" {
+				name = p.Name
+			}
+			relationships = append(relationships, relationship{"SPDXRef-DOCUMENT", "DESCRIBES", p.spdxID()})
+		}
+		for _, d := range p.Dependencies {
+			if locked := findLocked(packages, d); locked != nil {
+				relationships = append(relationships, relationship{p.spdxID(), "DEPENDS_ON", locked.spdxID()})
+			}
+		}
+	}
+
+	doc := map[string]any{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              name,
+		"documentNamespace": fmt.Sprintf("https://spdx.org/spdxdocs/%s-%s", name, id),
+		"creationInfo": map[string]any{
+			"created":  time.Now().UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: dagger-cargo"},
+		},
+		"packages":      pkgs,
+		"relationships": relationships,
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	return string(out), err
+}
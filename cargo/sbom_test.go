@@ -0,0 +1,107 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseCargoLock(t *testing.T) {
+	lock := `# This file is automatically @generated by Cargo.
+version = 3
+
+[[package]]
+name = "app"
+version = "0.1.0"
+dependencies = [
+ "serde",
+ "syn 2.0.0",
+]
+
+[[package]]
+name = "serde"
+version = "1.0.200"
+source = "registry+https://github.com/rust-lang/crates.io-index"
+checksum = "abc123"
+
+[[package]]
+name = "syn"
+version = "2.0.0"
+source = "registry+https://github.com/rust-lang/crates.io-index"
+
+[metadata]
+"checksum x" = "ignored"
+`
+	packages := parseCargoLock(lock)
+	if len(packages) != 3 {
+		t.Fatalf("got %d packages, want 3", len(packages))
+	}
+	app, serde := packages[0], packages[1]
+	if app.Name != "app" || app.Source != "" || !slices.Equal(app.Dependencies, []string{"serde", "syn 2.0.0"}) {
+		t.Fatalf("app %+v", *app)
+	}
+	if serde.Version != "1.0.200" || serde.Source != CRATES_IO || serde.Checksum != "abc123" || len(serde.Dependencies) != 0 {
+		t.Fatalf("serde %+v", *serde)
+	}
+	if locked := findLocked(packages, "syn 2.0.0"); locked != packages[2] {
+		t.Fatalf("found %+v", locked)
+	}
+}
+
+func TestLockedPackageRefs(t *testing.T) {
+	lock := `[[package]]
+name = "app"
+version = "0.1.0"
+dependencies = [
+ "log 0.4.0 (registry+https://github.com/rust-lang/crates.io-index)",
+ "log 0.4.0 (git+https://github.com/rust-lang/log?branch=main#abc123)",
+]
+
+[[package]]
+name = "log"
+version = "0.4.0"
+source = "registry+https://github.com/rust-lang/crates.io-index"
+
+[[package]]
+name = "log"
+version = "0.4.0"
+source = "git+https://github.com/rust-lang/log?branch=main#abc123"
+
+[[package]]
+name = "log"
+version = "0.4.0"
+source = "sparse+https://registry.example.com/index/"
+`
+	packages := parseCargoLock(lock)
+	app, registry, git, sparse := packages[0], packages[1], packages[2], packages[3]
+
+	if app.purl() != "" || app.bomRef() != "app@0.1.0" {
+		t.Fatalf("project crate purl %q and ref %q", app.purl(), app.bomRef())
+	}
+	if got := registry.purl(); got != "pkg:cargo/log@0.4.0" {
+		t.Fatalf("crates.io purl %q", got)
+	}
+	if got := git.purl(); got != "pkg:cargo/log@0.4.0?vcs_url=git%2Bhttps%3A%2F%2Fgithub.com%2Frust-lang%2Flog%40abc123" {
+		t.Fatalf("git purl %q", got)
+	}
+	if got := sparse.purl(); got != "pkg:cargo/log@0.4.0?repository_url=https%3A%2F%2Fregistry.example.com%2Findex%2F" {
+		t.Fatalf("registry purl %q", got)
+	}
+
+	refs, ids := map[string]bool{}, map[string]bool{}
+	for _, p := range packages {
+		refs[p.bomRef()], ids[p.spdxID()] = true, true
+	}
+	if len(refs) != len(packages) || len(ids) != len(packages) {
+		t.Fatalf("duplicate references %v or SPDX ids %v", refs, ids)
+	}
+	if registry.spdxID() != "SPDXRef-Package-log-0.4.0" {
+		t.Fatalf("crates.io SPDX id %q", registry.spdxID())
+	}
+
+	if found := findLocked(packages, app.Dependencies[0]); found != registry {
+		t.Fatalf("found %+v for %q", found, app.Dependencies[0])
+	}
+	if found := findLocked(packages, app.Dependencies[1]); found != git {
+		t.Fatalf("found %+v for %q", found, app.Dependencies[1])
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"dagger/cargo/internal/dagger"
+)
+
+const (
+	SCCACHE_VERSION = "0.8.2"
+	SCCACHE_DIR     = "/sccache"
+	SCCACHE_STATS   = "/tmp/sccache.json"
+	// Alias of the S3 compatible service bound with WithSccache
+	S3_HOST = "s3"
+)
+
+// Compilation cache statistics reported by sccache
+type SccacheStats struct {
+	CompileRequests int
+	Hits            int
+	Misses          int
+	// Hits over hits and misses, in percent
+	HitRate float64
+}
+
+// Output of `sccache --show-stats --stats-format json`
+type sccacheOutput struct {
+	Stats struct {
+		CompileRequests int `json:"compile_requests"`
+		CacheHits       struct {
+			Counts map[string]int `json:"counts"`
+		} `json:"cache_hits"`
+		CacheMisses struct {
+			Counts map[string]int `json:"counts"`
+		} `json:"cache_misses"`
+	} `json:"stats"`
+}
+
+// Compile through sccache. The cache lives in a cache volume by default, or in
+// an S3 bucket, e.g. on a local stand-in service bound as host "s3"
+func (c *Cargo) WithSccache(
+	// +optional
+	cache *dagger.CacheVolume,
+	// +optional
+	bucket string,
+	// Defaults to http://s3:9000 when a service is given
+	// +optional
+	endpoint string,
+	// +optional
+	service *dagger.Service,
+	// +optional
+	accessKey *dagger.Secret,
+	// +optional
+	secretKey *dagger.Secret,
+) *Cargo {
+	if service != nil && endpoint == "" {
+		endpoint = fmt.Sprintf("http://%s:9000", S3_HOST)
+	}
+	cc := *c
+	cc.Sccache = true
+	cc.SccacheCache = cache
+	cc.SccacheBucket = bucket
+	cc.SccacheEndpoint = endpoint
+	cc.SccacheService = service
+	cc.SccacheAccessKey = accessKey
+	cc.SccacheSecretKey = secretKey
+	return &cc
+}
+
+// Run build or test and return the sccache statistics of the run
+func (c *Cargo) SccacheStats(
+	ctx context.Context,
+	command string,
+	// +optional
+	args []string,
+) (*SccacheStats, error) {
+	if !c.Sccache {
+		return nil, fmt.Errorf("sccache is not enabled, use WithSccache")
+	}
+	if command != "build" && command != "test" {
+		return nil, fmt.Errorf("unsupported command %q, expected build or test", command)
+	}
+	ctr, err := c.prepareOffline(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The sccache server only lives as long as the exec, so stats are read in the same one
+	script := `"$@" && sccache --show-stats --stats-format json > ` + SCCACHE_STATS
+	cargo := append(append([]string{"cargo", command}, c.offlineArgs()...), args...)
+	out, err := ctr.
+		WithExec(append([]string{"sh", "-c", script, "sh"}, cargo...)).
+		File(SCCACHE_STATS).
+		Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed sccacheOutput
+	if err := json.Unmarshal([]byte(out), &parsed); err != nil {
+		return nil, err
+	}
+	stats := &SccacheStats{CompileRequests: parsed.Stats.CompileRequests}
+	for _, n := range parsed.Stats.CacheHits.Counts {
+		stats.Hits += n
+	}
+	for _, n := range parsed.Stats.CacheMisses.Counts {
+		stats.Misses += n
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total) * 100
+	}
+	return stats, nil
+}
+
+// Route rustc through sccache, installed by prepare. Incremental compilation
+// is disabled since sccache cannot cache incremental builds
+func (c *Cargo) withSccache(ctr *dagger.Container) *dagger.Container {
+	if !c.Sccache {
+		return ctr
+	}
+	ctr = ctr.
+		WithEnvVariable("RUSTC_WRAPPER", "sccache").
+		WithEnvVariable("CARGO_INCREMENTAL", "0")
+
+	if c.SccacheBucket == "" {
+		cache := c.SccacheCache
+		if cache == nil {
+			cache = c.cacheVolume(nil, "sccache", "")
+		}
+		return ctr.
+			WithMountedCache(SCCACHE_DIR, cache).
+			WithEnvVariable("SCCACHE_DIR", SCCACHE_DIR)
+	}
+
+	ctr = ctr.
+		WithEnvVariable("SCCACHE_BUCKET", c.SccacheBucket).
+		WithEnvVariable("SCCACHE_REGION", "auto")
+	if c.SccacheEndpoint != "" {
+		ctr = ctr.WithEnvVariable("SCCACHE_ENDPOINT", c.SccacheEndpoint)
+	}
+	if c.SccacheService != nil {
+		ctr = ctr.
+			WithServiceBinding(S3_HOST, c.SccacheService).
+			WithEnvVariable("SCCACHE_S3_USE_SSL", "false")
+	}
+	if c.SccacheAccessKey != nil && c.SccacheSecretKey != nil {
+		ctr = ctr.
+			WithSecretVariable("AWS_ACCESS_KEY_ID", c.SccacheAccessKey).
+			WithSecretVariable("AWS_SECRET_ACCESS_KEY", c.SccacheSecretKey)
+	}
+	return ctr
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"dagger/cargo/internal/dagger"
+)
+
+const (
+	BASELINE_MOUNT = "/baseline"
+	// Supports the rustdoc JSON format of DEFAULT_RUST
+	CARGO_SEMVER_CHECKS_VERSION = "0.44.0"
+)
+
+var (
+	semverFailure = regexp.MustCompile(`^--- failure (\S+): (.*) ---$`)
+	semverSummary = regexp.MustCompile(`semver requires new (major|minor) version`)
+)
+
+// Result of cargo-semver-checks against a baseline
+type SemverReport struct {
+	// Bump required by the violations, major or minor. Empty when compatible
+	RequiredBump string
+	Violations   []*SemverViolation
+}
+
+type SemverViolation struct {
+	// Lint that failed, e.g. function_missing
+	Lint string
+	// Kind of break, e.g. "pub fn removed or renamed"
+	Kind string
+	// Item path and location, e.g. "function my_crate::parse, previously in file src/lib.rs:10"
+	Item string
+	// Bump required by the check run
+	RequiredBump string
+}
+
+// Check the public API against a baseline with cargo-semver-checks. The
+// baseline is a Directory, a git revision of the project, or a published
+// version, the latest one on crates.io by default. Fails when the declared
+// version bump is insufficient, unless allowBreaking is set
+func (c *Cargo) SemverCheck(
+	ctx context.Context,
+	// +optional
+	baseline *dagger.Directory,
+	// +optional
+	baselineRev string,
+	// +optional
+	baselineVersion string,
+	// +optional
+	allowBreaking bool,
+	// +optional
+	args []string,
+) (*SemverReport, error) {
+	set := 0
+	for _, b := range []bool{baseline != nil, baselineRev != "", baselineVersion != ""} {
+		if b {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, fmt.Errorf("only one of baseline, baselineRev and baselineVersion can be set")
+	}
+
+	ctr, err := c.prepareProject(ctx, cargoTool("cargo-semver-checks@"+CARGO_SEMVER_CHECKS_VERSION))
+	if err != nil {
+		return nil, err
+	}
+	ctr = ctr.WithEnvVariable("CARGO_TERM_COLOR", "never")
+
+	command := []string{"cargo", "semver-checks", "check-release"}
+	switch {
+	case baseline != nil:
+		ctr = ctr.WithMountedDirectory(BASELINE_MOUNT, baseline)
+		command = append(command, "--baseline-root", BASELINE_MOUNT)
+	case baselineRev != "":
+		command = append(command, "--baseline-rev", baselineRev)
+	case baselineVersion != "":
+		command = append(command, "--baseline-version", baselineVersion)
+	}
+
+	output, exit, err := execResult(ctx, execAllowFailure(ctr, append(command, args...)))
+	if err != nil {
+		return nil, err
+	}
+	report := parseSemverChecks(output)
+	switch {
+	case exit == 0:
+		return report, nil
+	case len(report.Violations) == 0:
+		return nil, fmt.Errorf("cargo semver-checks exited with code %d:\n%s", exit, tail(output, 30))
+	case allowBreaking:
+		return report, nil
+	}
+
+	var lines []string
+	for _, v := range report.Violations {
+		lines = append(lines, fmt.Sprintf("%s (%s): %s", v.Lint, v.Kind, v.Item))
+	}
+	return nil, fmt.Errorf("declared version bump is insufficient, semver requires a new %s version:\n%s", report.RequiredBump, strings.Join(lines, "\n"))
+}
+
+// Parse the human readable output of cargo-semver-checks, which has no
+// machine readable format. Each failed lint is followed by a "Failed in:"
+// list of items
+func parseSemverChecks(output string) *SemverReport {
+	report := &SemverReport{}
+	var lint, kind string
+	inItems := false
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if m := semverFailure.FindStringSubmatch(trimmed); m != nil {
+			lint, kind, inItems = m[1], m[2], false
+			continue
+		}
+		if m := semverSummary.FindStringSubmatch(trimmed); m != nil {
+			report.RequiredBump = m[1]
+			continue
+		}
+		switch {
+		case trimmed == "Failed in:":
+			inItems = lint != ""
+		case trimmed == "":
+			inItems = false
+		case inItems:
+			report.Violations = append(report.Violations, &SemverViolation{Lint: lint, Kind: kind, Item: trimmed})
+		}
+	}
+	for _, v := range report.Violations {
+		v.RequiredBump = report.RequiredBump
+	}
+	return report
+}
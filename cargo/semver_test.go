@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseSemverChecks(t *testing.T) {
+	output := `     Parsing app v1.1.0 (current)
+    Checking app v1.0.0 -> v1.1.0 (minor change)
+   Completed [   0.012s] 2 checks: 1 pass, 1 fail, 0 warn, 0 skip
+
+--- failure function_missing: pub fn removed or renamed ---
+
+Description:
+A publicly-visible function cannot be imported by its prior path.
+        ref: https://doc.rust-lang.org/cargo/reference/semver.html#item-remove
+
+Failed in:
+  function app::parse, previously in file src/lib.rs:10
+  function app::load, previously in file src/lib.rs:20
+
+     Summary semver requires new major version: 1 major and 0 minor checks failed
+`
+	report := parseSemverChecks(output)
+	if report.RequiredBump != "major" || len(report.Violations) != 2 {
+		t.Fatalf("got bump %q and %d violations", report.RequiredBump, len(report.Violations))
+	}
+	v := report.Violations[1]
+	if v.Lint != "function_missing" || v.Kind != "pub fn removed or renamed" || v.Item != "function app::load, previously in file src/lib.rs:20" || v.RequiredBump != "major" {
+		t.Fatalf("violation %+v", *v)
+	}
+	if clean := parseSemverChecks("     Summary no semver update required\n"); clean.RequiredBump != "" || len(clean.Violations) != 0 {
+		t.Fatalf("compatible report %+v", *clean)
+	}
+}
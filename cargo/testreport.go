@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"dagger/cargo/internal/dagger"
+)
+
+// Result of a test run, one suite per test binary or doc-test run
+type TestReport struct {
+	Passed   int
+	Failed   int
+	Ignored  int
+	Duration float64
+	Suites   []*TestSuite
+}
+
+type TestSuite struct {
+	Name     string
+	Passed   int
+	Failed   int
+	Ignored  int
+	Duration float64
+	Tests    []*TestCase
+}
+
+type TestCase struct {
+	Name string
+	// One of passed, failed or ignored
+	Status   string
+	Duration float64
+	// Output of the test when it failed
+	Message string
+}
+
+const (
+	// Run the test binaries, and rustdoc for the doc tests, with the unstable
+	// libtest JSON output allowed. Setting RUSTC_BOOTSTRAP for the whole cargo
+	// test would also compile the project and its dependencies as on nightly
+	LIBTEST_RUNNER  = "/usr/local/bin/libtest-runner"
+	LIBTEST_RUSTDOC = "/usr/local/bin/libtest-rustdoc"
+)
+
+// Event printed by libtest with --format json
+type libtestEvent struct {
+	Type     string  `json:"type"`
+	Event    string  `json:"event"`
+	Name     string  `json:"name"`
+	ExecTime float64 `json:"exec_time"`
+	Stdout   string  `json:"stdout"`
+	Message  string  `json:"message"`
+}
+
+// Test the project and return a structured report.
+// Failing tests do not fail the call, check the Failed count instead
+func (c *Cargo) TestReport(ctx context.Context, args []string) (*TestReport, error) {
+	ctr, err := c.prepareOffline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	executable := dagger.ContainerWithNewFileOpts{Permissions: 0o755}
+	ctr = ctr.
+		WithNewFile(LIBTEST_RUNNER, "#!/bin/sh\nRUSTC_BOOTSTRAP=1 exec \"$@\"\n", executable).
+		WithNewFile(LIBTEST_RUSTDOC, "#!/bin/sh\nRUSTC_BOOTSTRAP=1 exec rustdoc \"$@\"\n", executable).
+		WithEnvVariable("RUSTDOC", LIBTEST_RUSTDOC)
+	// Without --no-fail-fast, cargo stops at the first failing test binary and
+	// the later suites, doc tests included, would be missing from the report
+	command := []string{"cargo", "test", "--no-fail-fast", "--config", fmt.Sprintf("target.'cfg(all())'.runner = %q", LIBTEST_RUNNER)}
+	command = append(append(command, c.offlineArgs()...), libtestJSONArgs(args)...)
+	ctr = execAllowFailure(ctr, command)
+
+	output, exit, err := execResult(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+	report := parseLibtest(output)
+	if exit != 0 && len(report.Suites) == 0 {
+		return nil, fmt.Errorf("cargo test exited with code %d:\n%s", exit, tail(output, 30))
+	}
+	return report, nil
+}
+
+// Export the report as a JUnit XML file
+func (r *TestReport) Junit() *dagger.File {
+	type failure struct {
+		Message string `xml:"message,attr"`
+		Body    string `xml:",chardata"`
+	}
+	type testcase struct {
+		Name      string    `xml:"name,attr"`
+		Classname string    `xml:"classname,attr"`
+		Time      float64   `xml:"time,attr"`
+		Failure   *failure  `xml:"failure,omitempty"`
+		Skipped   *struct{} `xml:"skipped,omitempty"`
+	}
+	type testsuite struct {
+		Name      string     `xml:"name,attr"`
+		Tests     int        `xml:"tests,attr"`
+		Failures  int        `xml:"failures,attr"`
+		Skipped   int        `xml:"skipped,attr"`
+		Time      float64    `xml:"time,attr"`
+		Testcases []testcase `xml:"testcase"`
+	}
+	type testsuites struct {
+		XMLName  xml.Name    `xml:"testsuites"`
+		Tests    int         `xml:"tests,attr"`
+		Failures int         `xml:"failures,attr"`
+		Skipped  int         `xml:"skipped,attr"`
+		Time     float64     `xml:"time,attr"`
+		Suites   []testsuite `xml:"testsuite"`
+	}
+
+	doc := testsuites{
+		Tests:    r.Passed + r.Failed + r.Ignored,
+		Failures: r.Failed,
+		Skipped:  r.Ignored,
+		Time:     r.Duration,
+	}
+	for _, s := range r.Suites {
+		suite := testsuite{
+			Name:     s.Name,
+			Tests:    len(s.Tests),
+			Failures: s.Failed,
+			Skipped:  s.Ignored,
+			Time:     s.Duration,
+		}
+		for _, t := range s.Tests {
+			tc := testcase{Name: t.Name, Classname: s.Name, Time: t.Duration}
+			switch t.Status {
+			case "failed":
+				tc.Failure = &failure{Message: "test failed", Body: t.Message}
+			case "ignored":
+				tc.Skipped = &struct{}{}
+			}
+			suite.Testcases = append(suite.Testcases, tc)
+		}
+		doc.Suites = append(doc.Suites, suite)
+	}
+
+	// Marshalling only fails on unsupported types, which the structs above are not
+	out, _ := xml.MarshalIndent(doc, "", "  ")
+	return dag.Directory().
+		WithNewFile("junit.xml", xml.Header+string(out)).
+		File("junit.xml")
+}
+
+// Add the libtest flags for JSON output, after any user provided test binary args
+func libtestJSONArgs(args []string) []string {
+	jsonArgs := []string{"-Z", "unstable-options", "--format", "json", "--report-time"}
+	for i, arg := range args {
+		if arg == "--" {
+			out := append([]string{}, args[:i+1]...)
+			out = append(out, jsonArgs...)
+			return append(out, args[i+1:]...)
+		}
+	}
+	return append(append(args, "--"), jsonArgs...)
+}
+
+// Parse the combined cargo and libtest output. Cargo announces each test
+// binary with a "Running" or "Doc-tests" line before libtest JSON events
+func parseLibtest(output string) *TestReport {
+	report := &TestReport{}
+	var suite *TestSuite
+	name := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if rest, ok := strings.CutPrefix(line, "Running "); ok {
+			name, _, _ = strings.Cut(rest, " (")
+			continue
+		}
+		if strings.HasPrefix(line, "Doc-tests ") {
+			name = line
+			continue
+		}
+
+		var ev libtestEvent
+		if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &ev) != nil {
+			continue
+		}
+		switch {
+		case ev.Type == "suite" && ev.Event == "started":
+			if name == "" {
+				name = fmt.Sprintf("suite-%d", len(report.Suites)+1)
+			}
+			suite = &TestSuite{Name: name}
+			report.Suites = append(report.Suites, suite)
+			name = ""
+		case ev.Type == "suite" && suite != nil:
+			suite.Duration = ev.ExecTime
+			report.Duration += ev.ExecTime
+		case ev.Type == "test" && suite != nil && ev.Event != "started":
+			tc := &TestCase{Name: ev.Name, Duration: ev.ExecTime}
+			switch ev.Event {
+			case "ok":
+				tc.Status = "passed"
+				suite.Passed++
+			case "ignored":
+				tc.Status = "ignored"
+				suite.Ignored++
+			default:
+				tc.Status = "failed"
+				tc.Message = ev.Stdout + ev.Message
+				suite.Failed++
+			}
+			suite.Tests = append(suite.Tests, tc)
+		}
+	}
+
+	for _, s := range report.Suites {
+		report.Passed += s.Passed
+		report.Failed += s.Failed
+		report.Ignored += s.Ignored
+	}
+	return report
+}
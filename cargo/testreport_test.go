@@ -0,0 +1,88 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseLibtest(t *testing.T) {
+	output := `   Compiling app v0.1.0 (/src)
+     Running unittests src/lib.rs (target/debug/deps/app-0123)
+{ "type": "suite", "event": "started", "test_count": 3 }
+{ "type": "test", "event": "started", "name": "tests::passes" }
+{ "type": "test", "name": "tests::passes", "event": "ok", "exec_time": 0.001 }
+{ "type": "test", "name": "tests::fails", "event": "failed", "stdout": "boom\n", "exec_time": 0.002 }
+{ "type": "test", "name": "tests::skipped", "event": "ignored" }
+{ "type": "suite", "event": "failed", "passed": 1, "failed": 1, "ignored": 1, "exec_time": 0.25 }
+   Doc-tests app
+{ "type": "suite", "event": "started", "test_count": 1 }
+{ "type": "test", "name": "src/lib.rs - add (line 3)", "event": "ok", "exec_time": 0.5 }
+{ "type": "suite", "event": "ok", "passed": 1, "failed": 0, "ignored": 0, "exec_time": 0.75 }
+`
+	report := parseLibtest(output)
+	if report.Passed != 2 || report.Failed != 1 || report.Ignored != 1 || report.Duration != 1 {
+		t.Fatalf("totals %+v", *report)
+	}
+	if len(report.Suites) != 2 {
+		t.Fatalf("got %d suites, want 2", len(report.Suites))
+	}
+	unit, doc := report.Suites[0], report.Suites[1]
+	if unit.Name != "unittests src/lib.rs" || doc.Name != "Doc-tests app" {
+		t.Fatalf("suite names %q and %q", unit.Name, doc.Name)
+	}
+	if len(unit.Tests) != 3 {
+		t.Fatalf("got %d tests, want 3", len(unit.Tests))
+	}
+	failed := unit.Tests[1]
+	if failed.Status != "failed" || failed.Message != "boom\n" || failed.Duration != 0.002 {
+		t.Fatalf("failed test %+v", *failed)
+	}
+	if unit.Tests[2].Status != "ignored" {
+		t.Fatalf("ignored test %+v", *unit.Tests[2])
+	}
+}
+
+func TestLibtestJSONArgs(t *testing.T) {
+	tests := map[string]string{
+		"":                         "-- -Z unstable-options --format json --report-time",
+		"--doc":                    "--doc -- -Z unstable-options --format json --report-time",
+		"-p app -- --test-threads": "-p app -- -Z unstable-options --format json --report-time --test-threads",
+	}
+	for args, want := range tests {
+		if got := strings.Join(libtestJSONArgs(strings.Fields(args)), " "); got != want {
+			t.Errorf("%q: got %q, want %q", args, got, want)
+		}
+	}
+}
+
+func TestParseLibtestAfterFailingSuite(t *testing.T) {
+	output := `     Running unittests src/lib.rs (target/debug/deps/app-0123)
+{ "type": "suite", "event": "started", "test_count": 1 }
+{ "type": "test", "name": "tests::fails", "event": "failed", "stdout": "boom\n", "exec_time": 0.1 }
+{ "type": "suite", "event": "failed", "passed": 0, "failed": 1, "ignored": 0, "exec_time": 0.1 }
+error: test failed, to rerun pass ` + "`--lib`" + `
+     Running tests/api.rs (target/debug/deps/api-4567)
+{ "type": "suite", "event": "started", "test_count": 2 }
+{ "type": "test", "name": "get", "event": "ok", "exec_time": 0.2 }
+{ "type": "test", "name": "put", "event": "ok", "exec_time": 0.2 }
+{ "type": "suite", "event": "ok", "passed": 2, "failed": 0, "ignored": 0, "exec_time": 0.4 }
+   Doc-tests app
+{ "type": "suite", "event": "started", "test_count": 1 }
+{ "type": "test", "name": "src/lib.rs - add (line 3)", "event": "ok", "exec_time": 0.5 }
+{ "type": "suite", "event": "ok", "passed": 1, "failed": 0, "ignored": 0, "exec_time": 0.5 }
+error: 1 target failed:
+    ` + "`-p app --lib`" + `
+`
+	report := parseLibtest(output)
+	var names []string
+	for _, s := range report.Suites {
+		names = append(names, s.Name)
+	}
+	if want := []string{"unittests src/lib.rs", "tests/api.rs", "Doc-tests app"}; !slices.Equal(names, want) {
+		t.Fatalf("suites %q, want %q", names, want)
+	}
+	if report.Passed != 3 || report.Failed != 1 {
+		t.Fatalf("got %d passed and %d failed, want 3 and 1", report.Passed, report.Failed)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/sync/errgroup"
+
+	"dagger/cargo/internal/dagger"
+)
+
+// Installs the toolchain pinned by rust-toolchain.toml or rust-toolchain, if any.
+// rustup only accepts a bare `toolchain install` since 1.28, older ones install on `show`
+const INSTALL_PINNED_TOOLCHAIN = `if [ -f rust-toolchain.toml ] || [ -f rust-toolchain ]; then rustup toolchain install || rustup show; fi`
+
+// Files pinning the toolchain, mounted alone to install it before the project
+var TOOLCHAIN_FILES = []string{"rust-toolchain.toml", "rust-toolchain"}
+
+var rustupChannel = regexp.MustCompile(`^(stable|beta|nightly)(-\d{4}-\d{2}-\d{2})?$`)
+
+// Rust toolchain requirements declared by the project
+type Toolchain struct {
+	// Channel pinned by rust-toolchain.toml or rust-toolchain, e.g. 1.75.0 or nightly-2024-01-01
+	Channel    string
+	Components []string
+	Targets    []string
+	// Minimum supported Rust version, from rust-version in Cargo.toml
+	Msrv string
+}
+
+// Result of a command run with a given Rust version
+type ToolchainResult struct {
+	Version  string
+	Success  bool
+	ExitCode int
+	Output   string
+}
+
+// rust-toolchain.toml, or the toml flavour of the legacy rust-toolchain file
+type toolchainFile struct {
+	Toolchain struct {
+		Channel    string   `toml:"channel"`
+		Components []string `toml:"components"`
+		Targets    []string `toml:"targets"`
+	} `toml:"toolchain"`
+}
+
+// Detect the toolchain requirements from the project files
+func (c *Cargo) DetectToolchain(ctx context.Context) (*Toolchain, error) {
+	if c.Proj == nil {
+		return nil, ErrNoProject
+	}
+	entries, err := c.Proj.Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &Toolchain{}
+	for _, name := range TOOLCHAIN_FILES {
+		if !slices.Contains(entries, name) {
+			continue
+		}
+		content, err := c.Proj.File(name).Contents(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if tc, err = parseToolchainFile(content); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		break
+	}
+
+	if slices.Contains(entries, "Cargo.toml") {
+		content, err := c.Proj.File("Cargo.toml").Contents(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if tc.Msrv, err = parseMsrv(content); err != nil {
+			return nil, fmt.Errorf("Cargo.toml: %w", err)
+		}
+	}
+	return tc, nil
+}
+
+// Build the project with the declared MSRV and the stable channel side by side.
+// Fails when Cargo.toml declares no rust-version
+func (c *Cargo) MsrvCheck(
+	ctx context.Context,
+	// +optional
+	args []string,
+) ([]*ToolchainResult, error) {
+	if err := c.validate(ctx); err != nil {
+		return nil, err
+	}
+	tc, err := c.DetectToolchain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tc.Msrv == "" {
+		return nil, errors.New("no rust-version in Cargo.toml, declare the MSRV in [package] or [workspace.package]")
+	}
+	versions := []string{tc.Msrv, "stable"}
+
+	command := append([]string{"cargo", "build"}, args...)
+	results := make([]*ToolchainResult, len(versions))
+	eg, gctx := errgroup.WithContext(ctx)
+	for i, version := range versions {
+		i, version := i, version
+		eg.Go(func() error {
+			ctr, err := c.versionContainer(gctx, version)
+			if err != nil {
+				return err
+			}
+			output, exit, err := execResult(gctx, execAllowFailure(ctr, pinToolchain(command)))
+			if err != nil {
+				return err
+			}
+			results[i] = &ToolchainResult{Version: version, Success: exit == 0, ExitCode: exit, Output: output}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Prepare a container for the given version
+func (c *Cargo) versionContainer(ctx context.Context, version string) (*dagger.Container, error) {
+	return c.Base(version).prepare(ctx)
+}
+
+// Wrap a command to use the toolchain set up by Base, ignoring any toolchain
+// pinned by the project
+func pinToolchain(command []string) []string {
+	return append([]string{"sh", "-c", `RUSTUP_TOOLCHAIN="$RUST_VERSION" exec "$@"`, "sh"}, command...)
+}
+
+// Whether the version is a rustup channel rather than a rust image tag
+func isChannel(version string) bool {
+	return rustupChannel.MatchString(version)
+}
+
+// Parse a rust-toolchain.toml, or a legacy rust-toolchain file holding the channel alone
+func parseToolchainFile(content string) (*Toolchain, error) {
+	var file toolchainFile
+	if _, err := toml.Decode(content, &file); err != nil {
+		channel := strings.TrimSpace(content)
+		if strings.ContainsAny(channel, "\n=[") {
+			return nil, err
+		}
+		return &Toolchain{Channel: channel}, nil
+	}
+	return &Toolchain{
+		Channel:    file.Toolchain.Channel,
+		Components: file.Toolchain.Components,
+		Targets:    file.Toolchain.Targets,
+	}, nil
+}
+
+// MSRV declared by a Cargo.toml, from [package] or inherited from [workspace.package]
+func parseMsrv(content string) (string, error) {
+	var manifest cargoManifest
+	if _, err := toml.Decode(content, &manifest); err != nil {
+		return "", err
+	}
+	if manifest.Package != nil {
+		switch v := manifest.Package.RustVersion.(type) {
+		case string:
+			return v, nil
+		case map[string]any:
+			if v["workspace"] != true {
+				return "", fmt.Errorf("unsupported rust-version table, expected workspace = true")
+			}
+		}
+	}
+	return manifest.Workspace.Package.RustVersion, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseToolchainFile(t *testing.T) {
+	file := `# Pinned for the whole team
+[toolchain]
+channel = '1.75.0' # comment
+components = ["clippy", 'rustfmt']
+targets = [
+  "wasm32-unknown-unknown",
+]
+`
+	tc, err := parseToolchainFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.Channel != "1.75.0" || !slices.Equal(tc.Components, []string{"clippy", "rustfmt"}) || !slices.Equal(tc.Targets, []string{"wasm32-unknown-unknown"}) {
+		t.Fatalf("toolchain %+v", *tc)
+	}
+	if legacy, err := parseToolchainFile("nightly-2024-01-01\n"); err != nil || legacy.Channel != "nightly-2024-01-01" {
+		t.Fatalf("legacy file: %+v, %v", legacy, err)
+	}
+	if _, err := parseToolchainFile("[toolchain\nchannel = \"stable\""); err == nil {
+		t.Fatalf("parsed an invalid file")
+	}
+}
+
+func TestParseMsrv(t *testing.T) {
+	tests := map[string]string{
+		"[package]\nname = 'app'\nrust-version = '1.70'\n":                                         "1.70",
+		"# [package]\n# rust-version = \"1.0\"\n[package]\nrust-version = \"1.71\" # MSRV\n":       "1.71",
+		"[package]\nrust-version.workspace = true\n\n[workspace.package]\nrust-version = \"1.65\"": "1.65",
+		"[workspace]\nmembers = [\"a\"]\n[workspace.package]\nrust-version = \"1.66\"":             "1.66",
+		"[package]\nname = \"app\"\n":                                                              "",
+	}
+	for manifest, want := range tests {
+		got, err := parseMsrv(manifest)
+		if err != nil || got != want {
+			t.Errorf("%q: got %q, %v, want %q", manifest, got, err, want)
+		}
+	}
+	if _, err := parseMsrv("[package]\nrust-version = { path = \"x\" }\n"); err == nil {
+		t.Errorf("parsed an unsupported rust-version table")
+	}
+}
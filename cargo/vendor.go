@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"regexp"
+
+	"dagger/cargo/internal/dagger"
+)
+
+const (
+	VENDOR_MOUNT  = "/vendor"
+	VENDOR_CONFIG = "/usr/local/cargo/config.toml"
+	// Written by cargo vendor for the default crates.io only setup
+	DEFAULT_VENDOR_CONFIG = `[source.crates-io]
+replace-with = "vendored-sources"
+
+[source.vendored-sources]
+directory = "vendor"
+`
+)
+
+var vendorDirectory = regexp.MustCompile(`(?m)^directory = ".*"$`)
+
+// Vendored dependencies, with the config that makes cargo use them
+type Vendored struct {
+	Directory *dagger.Directory
+	// Snippet for .cargo/config.toml, pointing at a vendor directory in the project root
+	Config string
+}
+
+// Vendor all dependencies with cargo vendor, for offline builds
+func (c *Cargo) Vendor(ctx context.Context) (*Vendored, error) {
+	ctr, err := c.prepareProject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctr = ctr.WithExec(
+		[]string{"cargo", "vendor", "--locked", "vendor"},
+		dagger.ContainerWithExecOpts{RedirectStdout: "/tmp/vendor.toml"},
+	)
+	config, err := ctr.File("/tmp/vendor.toml").Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Vendored{Directory: ctr.Directory(PROJ_MOUNT + "/vendor"), Config: config}, nil
+}
+
+// Use vendored dependencies: Build, Test, TestReport and SccacheStats then run
+// with --offline --frozen, and Sbom reads licenses from them. Other commands
+// and tool installs keep using the registry. The config defaults to the one
+// for crates.io only dependencies
+func (c *Cargo) WithVendored(
+	dir *dagger.Directory,
+	// +optional
+	config string,
+) *Cargo {
+	if config == "" {
+		config = DEFAULT_VENDOR_CONFIG
+	}
+	cc := *c
+	cc.Vendored = dir
+	cc.VendorConfig = config
+	return &cc
+}
+
+// Mount the vendored dependencies and point cargo at them
+func (c *Cargo) withVendored(ctr *dagger.Container) *dagger.Container {
+	if c.Vendored == nil {
+		return ctr
+	}
+	config := vendorDirectory.ReplaceAllString(c.VendorConfig, `directory = "`+VENDOR_MOUNT+`"`)
+	return ctr.
+		WithMountedDirectory(VENDOR_MOUNT, c.Vendored).
+		WithNewFile(VENDOR_CONFIG, config).
+		WithEnvVariable("CARGO_NET_OFFLINE", "true")
+}
+
+// Prepare the container for a command run offline against the vendored
+// dependencies, if any, together with offlineArgs
+func (c *Cargo) prepareOffline(ctx context.Context) (*dagger.Container, error) {
+	ctr, err := c.prepareProject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.withVendored(ctr), nil
+}
+
+// Flags for commands that must not touch the network once vendored
+func (c *Cargo) offlineArgs() []string {
+	if c.Vendored == nil {
+		return nil
+	}
+	return []string{"--offline", "--frozen"}
+}
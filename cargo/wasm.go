@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"dagger/cargo/internal/dagger"
+)
+
+const (
+	WASM_TARGET      = "wasm32-unknown-unknown"
+	WASI_TARGET      = "wasm32-wasip1"
+	WASM_RAW         = "/tmp/wasm"
+	WASMTIME_VERSION = "25.0.0"
+	// Bundles binaryen 116, newer than the distribution package
+	WASM_OPT_VERSION = "0.116.1"
+)
+
+// Proposals rustc enables by default for wasm targets, which wasm-opt rejects
+// unless they are enabled too
+var wasmFeatures = []string{
+	"--enable-bulk-memory",
+	"--enable-mutable-globals",
+	"--enable-multivalue",
+	"--enable-nontrapping-float-to-int",
+	"--enable-reference-types",
+	"--enable-sign-ext",
+}
+
+// Version of the wasm-bindgen crate locked by the project
+var lockedBindgen = regexp.MustCompile(`name = "wasm-bindgen"\nversion = "([^"]+)"`)
+
+// Build the project for wasm32-unknown-unknown or wasm32-wasip1 in release mode
+// and return the .wasm artifacts. With bindgen, JS bindings are generated by
+// the wasm-bindgen CLI matching the locked crate version; with optimize, the
+// modules are shrunk by wasm-opt
+func (c *Cargo) BuildWasm(
+	ctx context.Context,
+	// +optional
+	// +default="wasm32-unknown-unknown"
+	target string,
+	// +optional
+	bindgen bool,
+	// Output of wasm-bindgen: web, bundler, nodejs, no-modules or deno
+	// +optional
+	// +default="web"
+	bindgenTarget string,
+	// +optional
+	optimize bool,
+	// +optional
+	args []string,
+) (*dagger.Directory, error) {
+	if target == "" {
+		target = WASM_TARGET
+	}
+	if target != WASM_TARGET && target != WASI_TARGET {
+		return nil, fmt.Errorf("unsupported wasm target %q, expected %s or %s", target, WASM_TARGET, WASI_TARGET)
+	}
+	if bindgenTarget == "" {
+		bindgenTarget = "web"
+	}
+
+	if err := c.validate(ctx); err != nil {
+		return nil, err
+	}
+	tools := []tool{rustupTarget(target)}
+	if bindgen {
+		version, err := c.lockedBindgenVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+		crate := "wasm-bindgen-cli"
+		if version != "" {
+			crate += "@" + version
+		}
+		tools = append(tools, cargoTool(crate))
+	}
+	if optimize {
+		tools = append(tools, cargoTool("wasm-opt@"+WASM_OPT_VERSION))
+	}
+
+	ctr, err := c.prepareProject(ctx, tools...)
+	if err != nil {
+		return nil, err
+	}
+	command := append([]string{"cargo", "build", "--release", "--target", target}, args...)
+	modules, err := buildArtifacts(ctx, ctr, command, wasmModules)
+	if err != nil {
+		return nil, err
+	}
+	ctr = ctr.
+		WithMountedDirectory(WASM_RAW, modules).
+		WithExec([]string{"mkdir", "-p", OUT_MOUNT})
+
+	if bindgen {
+		ctr = ctr.WithExec([]string{"sh", "-c", fmt.Sprintf(
+			`for f in %s/*.wasm; do wasm-bindgen --target %s --out-dir %s "$f"; done`,
+			WASM_RAW, bindgenTarget, OUT_MOUNT,
+		)})
+	} else {
+		ctr = ctr.WithExec([]string{"sh", "-c", fmt.Sprintf("cp %s/*.wasm %s/", WASM_RAW, OUT_MOUNT)})
+	}
+
+	if optimize {
+		ctr = ctr.WithExec([]string{"sh", "-c", fmt.Sprintf(
+			`for f in %s/*.wasm; do wasm-opt -Oz %s "$f" -o "$f"; done`,
+			OUT_MOUNT, strings.Join(wasmFeatures, " "),
+		)})
+	}
+	return ctr.Directory(OUT_MOUNT), nil
+}
+
+// Run the tests compiled for wasm32-wasip1 under wasmtime
+func (c *Cargo) TestWasm(
+	ctx context.Context,
+	// +optional
+	args []string,
+) (string, error) {
+	ctr, err := c.prepareProject(ctx, rustupTarget(WASI_TARGET), cargoTool("wasmtime-cli@"+WASMTIME_VERSION))
+	if err != nil {
+		return "", err
+	}
+	return ctr.
+		WithEnvVariable("CARGO_TARGET_WASM32_WASIP1_RUNNER", "wasmtime").
+		WithExec(append([]string{"cargo", "test", "--target", WASI_TARGET}, args...)).
+		Stdout(ctx)
+}
+
+// Version of wasm-bindgen in Cargo.lock, empty without a lockfile
+func (c *Cargo) lockedBindgenVersion(ctx context.Context) (string, error) {
+	entries, err := c.Proj.Entries(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(entries, "Cargo.lock") {
+		return "", nil
+	}
+	lock, err := c.Proj.File("Cargo.lock").Contents(ctx)
+	if err != nil {
+		return "", err
+	}
+	if m := lockedBindgen.FindStringSubmatch(lock); m != nil {
+		return m[1], nil
+	}
+	return "", nil
+}
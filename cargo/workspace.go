@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// A crate of the Cargo workspace
+type WorkspaceMember struct {
+	Name    string
+	Version string
+	// Path of the crate, relative to the workspace root
+	Path         string
+	Dependencies []*CrateDependency
+}
+
+type CrateDependency struct {
+	Name string
+	// Version requirement, e.g. ^1.0
+	Req string
+	// Empty for normal dependencies, dev or build otherwise
+	Kind string
+}
+
+// Result of a command run on a single workspace member
+type CrateResult struct {
+	Name     string
+	Success  bool
+	ExitCode int
+	Output   string
+}
+
+// List the members of the Cargo workspace
+func (c *Cargo) Workspace(ctx context.Context) ([]*WorkspaceMember, error) {
+	ctr, err := c.prepareProject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := metadata(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []*WorkspaceMember
+	for _, pkg := range meta.Packages {
+		if !slices.Contains(meta.WorkspaceMembers, pkg.ID) {
+			continue
+		}
+		path, err := filepath.Rel(meta.WorkspaceRoot, filepath.Dir(pkg.ManifestPath))
+		if err != nil {
+			return nil, err
+		}
+		member := &WorkspaceMember{Name: pkg.Name, Version: pkg.Version, Path: path}
+		for _, dep := range pkg.Dependencies {
+			member.Dependencies = append(member.Dependencies, &CrateDependency{Name: dep.Name, Req: dep.Req, Kind: dep.Kind})
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// Run build, test or clippy on each workspace member in parallel, each with
+// its own target directory in the target cache.
+// A failing crate does not fail the call, check each result instead.
+// Defaults to every member of the workspace
+func (c *Cargo) WorkspaceRun(
+	ctx context.Context,
+	command string,
+	// +optional
+	members []string,
+	// +optional
+	args []string,
+) ([]*CrateResult, error) {
+	if command != "build" && command != "test" && command != "clippy" {
+		return nil, fmt.Errorf("unsupported workspace command %q, expected build, test or clippy", command)
+	}
+
+	ctr, err := c.prepareProject(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		all, err := c.Workspace(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range all {
+			members = append(members, m.Name)
+		}
+	}
+
+	results := make([]*CrateResult, len(members))
+	eg, gctx := errgroup.WithContext(ctx)
+	for i, member := range members {
+		i, member := i, member
+		eg.Go(func() error {
+			// Cargo locks the target directory for the whole build, a shared
+			// one would run the members one at a time
+			memberCtr := ctr.WithEnvVariable("CARGO_TARGET_DIR", PROJ_TARGET+"/members/"+member)
+			cmd := append([]string{"cargo", command, "-p", member}, args...)
+			output, exit, err := execResult(gctx, execAllowFailure(memberCtr, cmd))
+			if err != nil {
+				return err
+			}
+			results[i] = &CrateResult{Name: member, Success: exit == 0, ExitCode: exit, Output: output}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}